@@ -0,0 +1,1144 @@
+package main
+
+import (
+	"EhDownloader/config"
+	"EhDownloader/convert"
+	"EhDownloader/eh"
+	"EhDownloader/export"
+	"EhDownloader/history"
+	"EhDownloader/hook"
+	"EhDownloader/i18n"
+	"EhDownloader/listfile"
+	"EhDownloader/metadata"
+	"EhDownloader/progress"
+	"EhDownloader/queue"
+	"EhDownloader/report"
+	"EhDownloader/utils"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// envPrefix 与参数对应的环境变量前缀，如--output对应EHDL_OUTPUT
+const envPrefix = "EHDL_"
+
+var (
+	successColor = color.New(color.Bold, color.FgGreen).FprintlnFunc()
+	failColor    = color.New(color.Bold, color.FgRed).FprintlnFunc()
+)
+
+// configFlags 选择配置文件、配置与界面语言的参数，每个子命令都有
+func configFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "config", Usage: i18n.Sprintf("配置文件路径，默认为用户配置目录下的EhDownloader/%s", config.FileName)},
+		&cli.StringFlag{Name: "profile", Aliases: []string{"p"}, Usage: i18n.T("使用配置文件中的指定配置，不指定时使用其中的default")},
+		langFlag(),
+	}
+}
+
+// langFlag 选择界面语言的参数，既可写在子命令前也可写在子命令后
+func langFlag() cli.Flag {
+	return &cli.StringFlag{Name: "lang", Usage: i18n.Sprintf("界面语言：%s，默认按LC_ALL、LC_MESSAGES、LANG判断", strings.Join(i18n.Langs, ", "))}
+}
+
+// applyLang 按--lang设置界面语言，未指定时保持从环境变量判断的语言
+func applyLang(c *cli.Context) error {
+	name := c.String("lang")
+	if name == "" {
+		return nil
+	}
+	lang, err := i18n.Parse(name)
+	if err != nil {
+		return usage(err)
+	}
+	i18n.Set(lang)
+	return nil
+}
+
+// beforeCommand 子命令执行前应用配置文件与界面语言
+func beforeCommand(c *cli.Context) error {
+	recordCommandLine(c)
+	if err := applyProfile(c); err != nil {
+		return err
+	}
+	return applyLang(c)
+}
+
+// presetLang 在解析参数前按--lang或EHDL_LANG设置语言，使帮助信息也使用所选语言，无效的值留给beforeCommand报错
+func presetLang(args []string) {
+	name := os.Getenv(envPrefix + "LANG")
+	for i, arg := range args {
+		if value, found := strings.CutPrefix(arg, "--lang="); found {
+			name = value
+		} else if arg == "--lang" && i+1 < len(args) {
+			name = args[i+1]
+		}
+	}
+	if lang, err := i18n.Parse(name); err == nil {
+		i18n.Set(lang)
+	}
+}
+
+// networkFlags 访问E-Hentai时的网络参数
+func networkFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "cookie", Destination: &cookie, Usage: i18n.T("请求时附带的Cookie，如ipb_member_id=...; ipb_pass_hash=...")},
+		&cli.StringFlag{Name: "user-agent", Destination: &userAgent, Usage: i18n.T("请求时使用的User-Agent，默认为内置的Chrome UA")},
+		&cli.StringSliceFlag{Name: "header", Destination: &headers, Usage: i18n.T("额外的请求头，形如\"Referer: https://e-hentai.org/\"，可多次指定")},
+		&cli.StringFlag{Name: "proxy", Destination: &proxy, Usage: i18n.T("代理地址，支持http、https与socks5，如socks5://127.0.0.1:1080")},
+		&cli.IntFlag{Name: "parallelism", Destination: &parallelism, Value: utils.Parallelism, Usage: i18n.T("同时下载的图片数")},
+		&cli.DurationFlag{Name: "delay", Destination: &delay, Usage: i18n.T("每张图片开始下载之间的等待时间，默认随机等待2-3秒")},
+		&cli.IntFlag{Name: "thumbs-per-page", Destination: &thumbsPerPage, Value: 40, Usage: i18n.T("目录页每页的缩略图数，需与网站上的设置一致")},
+	}
+}
+
+// withEnv 为每个参数加上对应的环境变量，优先级低于命令行参数
+func withEnv(flags []cli.Flag) []cli.Flag {
+	for _, flag := range flags {
+		env := []string{envPrefix + strings.ToUpper(strings.ReplaceAll(flag.Names()[0], "-", "_"))}
+		switch f := flag.(type) {
+		case *cli.StringFlag:
+			f.EnvVars = env
+		case *cli.BoolFlag:
+			f.EnvVars = env
+		case *cli.IntFlag:
+			f.EnvVars = env
+		case *cli.DurationFlag:
+			f.EnvVars = env
+		case *cli.StringSliceFlag:
+			f.EnvVars = env
+		}
+	}
+	return flags
+}
+
+// applyProfile 用配置文件中所选配置的值填充命令行和环境变量都没有指定的参数
+func applyProfile(c *cli.Context) error {
+	path := c.String("config")
+	if path == "" {
+		var err error
+		if path, err = config.DefaultPath(); err != nil {
+			return err
+		}
+	}
+	file, err := config.Load(path)
+	if err != nil {
+		return usage(err)
+	}
+	name := c.String("profile")
+	if name == "" {
+		name = file.Default
+	}
+	profile, err := file.Profile(name)
+	if err != nil || profile == nil {
+		return usage(err)
+	}
+
+	//配置中的设置对所有子命令通用，当前子命令没有的参数跳过，所有子命令都没有的视为写错
+	known := make(map[string]bool)
+	for _, command := range c.App.Commands {
+		for _, flag := range command.Flags {
+			known[flag.Names()[0]] = true
+		}
+	}
+	current := make(map[string]bool)
+	for _, flag := range c.Command.Flags {
+		current[flag.Names()[0]] = true
+	}
+	for _, key := range profile.Keys() {
+		if !known[key] || key == "config" || key == "profile" {
+			return usage(i18n.Errorf("配置%s中有未知的设置：%s", name, key))
+		}
+		if !current[key] || c.IsSet(key) {
+			continue
+		}
+		values, err := profile.Values(key)
+		if err != nil {
+			return usage(err)
+		}
+		for _, value := range values {
+			if err := c.Set(key, value); err != nil {
+				return usage(i18n.Errorf("配置中的设置%s无效：%w", key, err))
+			}
+		}
+	}
+	return nil
+}
+
+// parseNetwork 按参数生成网络设置
+func parseNetwork() (eh.Network, error) {
+	network := eh.Network{
+		UserAgent:     userAgent,
+		Cookie:        cookie,
+		Proxy:         proxy,
+		Parallelism:   parallelism,
+		Delay:         delay,
+		ThumbsPerPage: thumbsPerPage,
+	}
+	for _, header := range headers.Value() {
+		key, value, found := strings.Cut(header, ":")
+		if !found || strings.TrimSpace(key) == "" {
+			return network, i18n.Errorf("请求头格式错误，应为\"名称: 值\"：%s", header)
+		}
+		if network.Headers == nil {
+			network.Headers = make(map[string]string)
+		}
+		network.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return network, nil
+}
+
+// dirFlags 决定画廊目录位置与命名的参数
+func dirFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Destination: &outputDir, Value: "images", Usage: i18n.T("输出目录")},
+		&cli.BoolFlag{Name: "gid-dir", Destination: &gidDirName, Usage: i18n.Sprintf("新建的画廊目录以\"标题 [gid]\"命名，等同于--layout \"%s\"", eh.GidLayout)},
+		&cli.StringFlag{Name: "layout", Destination: &layoutFormat, Value: eh.DefaultLayout, Usage: i18n.T("画廊目录模板，以/分隔多级目录，可用字段：{title}、{title_jpn}、{category}、{artist}、{group}、{parody}、{language}、{gid}、{year}，{a|b}取第一个不为空的字段")},
+		&cli.StringSliceFlag{Name: "route", Destination: &routeRules, Usage: i18n.T("按标签把画廊放到其他根目录，形如language:chinese=/mnt/chinese，可多次指定")},
+		&cli.IntFlag{Name: "max-name-bytes", Destination: &maxNameBytes, Value: utils.DefaultSanitizePolicy.MaxBytes, Usage: i18n.T("目录名和文件名的最大字节数，超出时截断标题或原始文件名，0为不限制")},
+		&cli.BoolFlag{Name: "normalize-names", Destination: &normalizeNames, Usage: i18n.T("目录名和文件名统一为NFC并把全角字符转为半角")},
+	}
+}
+
+// filenameFlags 决定图片文件名的参数
+func filenameFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "filename", Destination: &filenameFormat, Value: utils.DefaultFilenameTemplate, Usage: i18n.T("图片文件名模板，可用字段：{index}、{index:3}、{index:auto}、{name}、{hash}、{gid}、{ext}")},
+		&cli.BoolFlag{Name: "original-names", Destination: &originalNames, Usage: i18n.Sprintf("按上传时的原始文件名保存图片，以序号作前缀，等同于--filename \"%s\"", utils.OriginalFilenameTemplate)},
+	}
+}
+
+// exportFlags 导出格式与转换的参数
+func exportFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "convert", Destination: &convertFormat, Usage: i18n.Sprintf("将图片转换为指定格式，动态GIF保持不变：%s", strings.Join(convert.Formats, ", "))},
+		&cli.IntFlag{Name: "quality", Destination: &convertQuality, Value: convert.DefaultQuality, Usage: i18n.T("转换为JPEG时的质量，1-100")},
+		&cli.StringSliceFlag{Name: "format", Destination: &formats, Usage: i18n.Sprintf("导出的格式，可多次指定：%s", strings.Join(export.Formats, ", "))},
+		&cli.BoolFlag{Name: "manga", Destination: &manga, Usage: i18n.T("导出时标记为从右到左阅读的漫画")},
+		&cli.IntFlag{Name: "volume-pages", Destination: &volumePages, Usage: i18n.T("CBZ每卷的最大页数，超过后拆分为多卷，0为不拆分")},
+	}
+}
+
+// downloadFlags download和retry共用的参数
+func downloadFlags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.BoolFlag{Name: "force", Aliases: []string{"f"}, Destination: &force, Usage: i18n.T("忽略下载历史，强制重新下载")},
+		&cli.BoolFlag{Name: "upgrade", Destination: &upgrade, Usage: i18n.T("画廊有更新版本时下载最新版本，并复用旧版本中相同的图片")},
+		&cli.BoolFlag{Name: "preview", Destination: &preview, Usage: i18n.T("只下载封面和缩略图并生成总览图，用于下载前预览")},
+		&cli.StringFlag{Name: "pages", Destination: &pageSpec, Usage: i18n.T("只下载选中的页，如1-20,35,40-，列表文件中可用pages=为单个画廊指定")},
+		&cli.IntFlag{Name: "retry", Aliases: []string{"r"}, Destination: &retryRounds, Value: 3, Usage: i18n.T("缺失图片的重试轮数")},
+		&cli.StringSliceFlag{Name: "metadata", Destination: &metadataNames, Usage: i18n.Sprintf("额外写入的元数据文件，可多次指定：%s", strings.Join(metadata.Names(), ", "))},
+		&cli.StringFlag{Name: "on-image", Destination: &onImage, Usage: i18n.T("每张图片保存后执行的命令，上下文见EH_开头的环境变量和stdin上的JSON")},
+		&cli.StringFlag{Name: "on-done", Destination: &onDone, Usage: i18n.T("画廊下载完成后执行的命令")},
+		&cli.StringFlag{Name: "on-failed", Destination: &onFailed, Usage: i18n.T("画廊下载失败后执行的命令")},
+		&cli.DurationFlag{Name: "hook-timeout", Destination: &hookTimeout, Value: hook.DefaultTimeout, Usage: i18n.T("钩子命令的超时时间")},
+		&cli.BoolFlag{Name: "json", Destination: &jsonOutput, Usage: i18n.T("在stdout上逐行输出JSON格式的下载事件，其余输出改到stderr")},
+		&cli.BoolFlag{Name: "no-progress", Destination: &noProgress, Usage: i18n.T("不显示进度条，逐张输出图片保存日志")},
+		&cli.BoolFlag{Name: "dry-run", Destination: &dryRun, Usage: i18n.T("只获取画廊信息并输出每个画廊的下载计划，不下载图片，可与--json同用")},
+	}
+	flags = append(flags, networkFlags()...)
+	flags = append(flags, dirFlags()...)
+	flags = append(flags, filenameFlags()...)
+	return append(flags, exportFlags()...)
+}
+
+// parseLayout 按参数生成画廊目录模板
+func parseLayout() (*eh.Layout, []eh.RouteRule, error) {
+	if gidDirName {
+		layoutFormat = eh.GidLayout
+	}
+	layout, err := eh.ParseLayout(layoutFormat)
+	if err != nil {
+		return nil, nil, err
+	}
+	layout.Policy = namePolicy()
+	var routes []eh.RouteRule
+	for _, raw := range routeRules.Value() {
+		rule, err := eh.ParseRouteRule(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		routes = append(routes, rule)
+	}
+	return layout, routes, nil
+}
+
+// parseFilenameTemplate 按参数生成图片文件名模板
+func parseFilenameTemplate() (*utils.FilenameTemplate, error) {
+	if originalNames {
+		filenameFormat = utils.OriginalFilenameTemplate
+	}
+	filenameTemplate, err := utils.ParseFilenameTemplate(filenameFormat)
+	if err != nil {
+		return nil, err
+	}
+	filenameTemplate.Policy = namePolicy()
+	return filenameTemplate, nil
+}
+
+func namePolicy() utils.SanitizePolicy {
+	return utils.SanitizePolicy{MaxBytes: maxNameBytes, Normalize: normalizeNames}
+}
+
+// parseExportOptions 检查导出格式与转换参数
+func parseExportOptions() (convert.Options, error) {
+	for _, format := range formats.Value() {
+		if !slices.Contains(export.Formats, format) {
+			return convert.Options{}, i18n.Errorf("不支持的导出格式：%s", format)
+		}
+	}
+	convertOpts := convert.Options{Format: convertFormat, Quality: convertQuality}
+	return convertOpts, convertOpts.Validate()
+}
+
+// openHistory 打开全局下载历史
+func openHistory() (*history.Store, error) {
+	historyPath, err := history.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	store, err := history.Open(historyPath)
+	if err != nil {
+		return nil, i18n.Errorf("无法打开下载历史%s：%w", historyPath, err)
+	}
+	return store, nil
+}
+
+// newDownloader 按参数创建使用store记录下载历史的下载器
+func newDownloader(store *history.Store) (*GalleryDownloader, eh.Options, error) {
+	convertOpts, err := parseExportOptions()
+	if err != nil {
+		return nil, eh.Options{}, usage(err)
+	}
+	metadataWriters, err := metadata.LookupAll(metadataNames.Value())
+	if err != nil {
+		return nil, eh.Options{}, usage(err)
+	}
+	layout, routes, err := parseLayout()
+	if err != nil {
+		return nil, eh.Options{}, usage(err)
+	}
+	filenameTemplate, err := parseFilenameTemplate()
+	if err != nil {
+		return nil, eh.Options{}, usage(err)
+	}
+	network, err := parseNetwork()
+	if err != nil {
+		return nil, eh.Options{}, usage(err)
+	}
+	pages, err := utils.ParsePageSelection(pageSpec)
+	if err != nil {
+		return nil, eh.Options{}, usage(err)
+	}
+
+	hooks := &hook.Runner{
+		Commands: map[hook.Event]string{hook.ImageSaved: onImage, hook.GalleryDone: onDone, hook.GalleryFailed: onFailed},
+		Timeout:  hookTimeout,
+	}
+	downloader := &GalleryDownloader{
+		InfoJsonPath: infoJsonPath,
+		History:      store,
+		Force:        force,
+		Metadata:     metadataWriters,
+		Convert:      convertOpts,
+		Hooks:        hooks,
+		Formats:      formats.Value(),
+		Export:       export.Options{Manga: manga, VolumePages: volumePages},
+	}
+	opts := eh.Options{
+		Preview:          preview,
+		RetryRounds:      retryRounds,
+		Pages:            pages,
+		Upgrade:          upgrade,
+		FilenameTemplate: filenameTemplate,
+		Layout:           layout,
+		Routes:           routes,
+		Network:          network,
+	}
+	return downloader, opts, nil
+}
+
+// galleryEntries 收集参数、-u或-l中的画廊，列表文件中可以为单个画廊指定选项，按优先级排序。
+// withQueue时列表文件的进度保存在对应的队列中，只返回未完成的画廊，从标准输入读取的列表没有队列
+func galleryEntries(c *cli.Context, withQueue bool) ([]listfile.Entry, *queue.Queue, error) {
+	urls := c.Args().Slice()
+	if url != "" {
+		urls = append(urls, url)
+	}
+	if listFilePath == "" {
+		if len(urls) == 0 {
+			return nil, nil, usage(i18n.Errorf("请指定画廊网址或列表文件"))
+		}
+		entries := make([]listfile.Entry, 0, len(urls))
+		for _, u := range urls {
+			entries = append(entries, listfile.Entry{URL: u})
+		}
+		return entries, nil, nil
+	}
+	if len(urls) > 0 {
+		return nil, nil, usage(i18n.Errorf("列表文件不能与画廊网址同时使用"))
+	}
+
+	entries, err := listfile.Read(listFilePath)
+	if err != nil {
+		return nil, nil, usage(i18n.Errorf("读取列表文件%s失败：%w", listFilePath, err))
+	}
+	if !withQueue || listFilePath == listfile.Stdin {
+		listfile.SortByPriority(entries)
+		return entries, nil, nil
+	}
+	jobQueue, err := queue.Load(queue.PathForList(listFilePath))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := jobQueue.Sync(listfile.URLs(entries)); err != nil {
+		return nil, nil, err
+	}
+	runnable := jobQueue.Runnable()
+	if skipped := len(jobQueue.Jobs) - len(runnable); skipped > 0 {
+		successColor(os.Stdout, i18n.T("队列中已完成的gallery数量:"), skipped)
+	}
+	return queuedEntries(runnable, entries), jobQueue, nil
+}
+
+// queuedEntries 按队列中的网址取出列表中对应的画廊及其选项，已从列表中删除的网址没有选项，按优先级排序
+func queuedEntries(urls []string, entries []listfile.Entry) []listfile.Entry {
+	byURL := make(map[string]listfile.Entry)
+	for _, entry := range entries {
+		if _, ok := byURL[entry.URL]; !ok {
+			byURL[entry.URL] = entry
+		}
+	}
+	queued := make([]listfile.Entry, 0, len(urls))
+	for _, u := range urls {
+		entry, ok := byURL[u]
+		if !ok {
+			entry = listfile.Entry{URL: u}
+		}
+		queued = append(queued, entry)
+	}
+	listfile.SortByPriority(queued)
+	return queued
+}
+
+// commandLine 命令行与环境变量中指定的参数值，在应用配置前记录，用于为列表中指定了其他配置的画廊重新解析参数
+var commandLine map[string][]string
+
+// recordCommandLine 记录命令行与环境变量中指定的参数值
+func recordCommandLine(c *cli.Context) {
+	commandLine = make(map[string][]string)
+	for _, flag := range c.Command.Flags {
+		name := flag.Names()[0]
+		if !c.IsSet(name) {
+			continue
+		}
+		if _, ok := flag.(*cli.StringSliceFlag); ok {
+			commandLine[name] = c.StringSlice(name)
+		} else {
+			commandLine[name] = []string{fmt.Sprint(c.Value(name))}
+		}
+	}
+}
+
+// useProfile 按name指定的配置代替--profile重新设置参数，name为空时使用配置文件中的default，命令行与环境变量中的参数仍然优先
+func useProfile(c *cli.Context, name string) error {
+	set := flag.NewFlagSet(c.Command.Name, flag.ContinueOnError)
+	for _, f := range c.Command.Flags {
+		//列表参数重复设置时会累加，重新解析前先清空
+		if slice, ok := f.(*cli.StringSliceFlag); ok && slice.Destination != nil {
+			*slice.Destination = cli.StringSlice{}
+		}
+		if err := f.Apply(set); err != nil {
+			return err
+		}
+	}
+	for key, values := range commandLine {
+		for _, value := range values {
+			if err := set.Set(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	if err := set.Set("profile", name); err != nil {
+		return err
+	}
+	ctx := cli.NewContext(c.App, set, c)
+	ctx.Command = c.Command
+	return applyProfile(ctx)
+}
+
+// galleryTask 一个要下载的画廊，及按列表中的选项确定的下载器、选项与输出目录
+type galleryTask struct {
+	URL        string
+	Downloader *GalleryDownloader
+	Opts       eh.Options
+	OutputDir  string
+}
+
+// newTasks 按参数与列表中的选项为每个画廊生成下载任务。指定了profile的画廊按该配置重新解析参数，同一配置的画廊共用一个下载器
+func newTasks(c *cli.Context, entries []listfile.Entry, store *history.Store, reporter *report.Writer, display *progress.Display) ([]galleryTask, error) {
+	type settings struct {
+		downloader *GalleryDownloader
+		opts       eh.Options
+		outputDir  string
+	}
+	build := func() (settings, error) {
+		downloader, opts, err := newDownloader(store)
+		if err != nil {
+			return settings{}, err
+		}
+		downloader.Report = reporter
+		downloader.Progress = display
+		return settings{downloader: downloader, opts: opts, outputDir: outputDir}, nil
+	}
+	base, err := build()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := map[string]settings{"": base}
+	tasks := make([]galleryTask, 0, len(entries))
+	for _, entry := range entries {
+		current, ok := profiles[entry.Profile]
+		if !ok {
+			if err := useProfile(c, entry.Profile); err != nil {
+				return nil, i18n.Errorf("列表文件%s第%d行：%w", listFilePath, entry.Line, err)
+			}
+			if current, err = build(); err != nil {
+				return nil, i18n.Errorf("列表文件%s第%d行：%w", listFilePath, entry.Line, err)
+			}
+			profiles[entry.Profile] = current
+		}
+		task := galleryTask{URL: entry.URL, Downloader: current.downloader, Opts: current.opts, OutputDir: current.outputDir}
+		if entry.Pages != nil {
+			task.Opts.Pages = entry.Pages
+		}
+		if entry.Output != "" {
+			task.OutputDir = entry.Output
+		}
+		tasks = append(tasks, task)
+	}
+	//恢复按--profile解析的参数
+	if len(profiles) > 1 {
+		if err := useProfile(c, c.String("profile")); err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// newReport --json时返回向w输出事件的Writer，否则返回nil
+func newReport(w io.Writer) *report.Writer {
+	if !jsonOutput {
+		return nil
+	}
+	return report.NewWriter(w)
+}
+
+// stdoutToStderr 暂时把stdout改到stderr，使--json时其余输出不与JSON混在一起，返回恢复stdout的函数
+func stdoutToStderr() func() {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	return func() { os.Stdout = stdout }
+}
+
+// newProgress 返回stdout上的进度显示，--json或--no-progress时返回nil
+func newProgress() *progress.Display {
+	if jsonOutput || noProgress {
+		return nil
+	}
+	return progress.NewStdout()
+}
+
+// galleryFinished 生成画廊下载结束的事件
+func galleryFinished(url string, result eh.Result, status string, err error) report.Event {
+	event := report.Event{
+		Type:    report.GalleryFinished,
+		URL:     url,
+		Gid:     result.Info.Gid,
+		Title:   result.Info.Title,
+		Dir:     result.BaseDir,
+		Total:   result.Total,
+		Done:    result.Total - len(result.Missing),
+		Missing: result.Missing,
+		Status:  status,
+	}
+	if event.Gid == "" {
+		event.Gid, _, _ = eh.ParseGalleryUrl(url)
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
+
+// tracked 预览和只获取信息不算下载，不改变队列中任务的状态，以免之后真正下载时被当作已完成而跳过
+func (t galleryTask) tracked(jobQueue *queue.Queue) bool {
+	return jobQueue != nil && !t.Opts.Preview && !t.Opts.OnlyInfo
+}
+
+// startJob 在队列中标记任务开始
+func startJob(jobQueue *queue.Queue, task galleryTask) error {
+	if !task.tracked(jobQueue) {
+		return nil
+	}
+	return jobQueue.Start(task.URL)
+}
+
+// finishJob 在队列中记录任务的结果，已有下载记录而跳过的画廊算作完成
+func finishJob(jobQueue *queue.Queue, task galleryTask, err error) error {
+	if !task.tracked(jobQueue) {
+		return nil
+	}
+	if errors.Is(err, errAlreadyDownloaded) {
+		err = nil
+	}
+	return jobQueue.Finish(task.URL, err)
+}
+
+// runDownloads 依次下载每个画廊，jobQueue不为nil时记录每个网址的进度。IP被封禁或配额用完时不再下载后面的画廊
+func runDownloads(tasks []galleryTask, jobQueue *queue.Queue, reporter *report.Writer, display *progress.Display) error {
+	//记录开始时间
+	startTime := time.Now()
+	succeeded, errCount, skipped := 0, 0, 0
+	var fatalErr error
+	display.Start(len(tasks))
+	//中途返回错误时也要恢复stdout，否则之后输出的错误会丢失
+	defer display.Stop()
+	for _, task := range tasks {
+		u := task.URL
+		successColor(os.Stdout, i18n.T("开始下载gallery:"), u)
+		_ = reporter.Emit(report.Event{Type: report.GalleryStarted, URL: u})
+		if err := startJob(jobQueue, task); err != nil {
+			return err
+		}
+		result, err := task.Downloader.Download(task.OutputDir, u, task.Opts)
+		display.FinishGallery()
+		if err := finishJob(jobQueue, task, err); err != nil {
+			return err
+		}
+		if errors.Is(err, errAlreadyDownloaded) {
+			successColor(os.Stdout, i18n.T("跳过:"), err, "\n")
+			_ = reporter.Emit(galleryFinished(u, result, "skipped", err))
+			skipped++
+		} else if err != nil {
+			failColor(os.Stderr, i18n.T("下载失败:"), err, "\n")
+			_ = reporter.Emit(galleryFinished(u, result, "failed", err))
+			errCount++
+			if exitCode(err) == exitBanned {
+				fatalErr = err
+				break
+			}
+		} else {
+			successColor(os.Stdout, i18n.T("gallery下载完毕:"), u, "\n")
+			_ = reporter.Emit(galleryFinished(u, result, "ok", nil))
+			succeeded++
+		}
+	}
+
+	display.Stop()
+	//记录结束时间
+	endTime := time.Now()
+	//计算执行时间，单位为秒
+	successColor(os.Stdout, i18n.T("所有gallery下载完毕，共耗时:"), getExecutionTime(startTime, endTime))
+	var err error
+	status := "ok"
+	if fatalErr != nil {
+		err = i18n.Errorf("已中止，剩余%d个gallery未下载：%w", len(tasks)-succeeded-errCount-skipped, fatalErr)
+		status = "aborted"
+	} else if errCount > 0 {
+		err = i18n.Errorf("有%d个下载失败", errCount)
+		status = "failed"
+	}
+	summary := report.Event{
+		Type:      report.Summary,
+		Total:     len(tasks),
+		Succeeded: succeeded,
+		Failed:    errCount,
+		Skipped:   skipped,
+		Duration:  endTime.Sub(startTime).Seconds(),
+		Status:    status,
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	_ = reporter.Emit(summary)
+	return err
+}
+
+// planDownloads --dry-run时获取每个画廊的信息并输出下载计划，不下载图片，也不记录历史和队列进度。
+// reporter不为nil时逐个输出plan事件，否则输出表格。IP被封禁或配额用完时不再获取后面的画廊
+func planDownloads(tasks []galleryTask, reporter *report.Writer) error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if reporter == nil {
+		_, _ = fmt.Fprintln(table, i18n.T("状态\t标题\t已有\t复用\t需下载\t预计大小\t目录或原因"))
+	}
+	planned, skipped, errCount, images, unknownSize := 0, 0, 0, 0, 0
+	var bytes int64
+	var fatalErr error
+	for _, task := range tasks {
+		u := task.URL
+		plan, err := task.Downloader.Plan(task.OutputDir, u, task.Opts)
+		event := report.Event{
+			Type:        report.Plan,
+			URL:         u,
+			Gid:         plan.Info.Gid,
+			Title:       plan.Info.Title,
+			Dir:         plan.Dir,
+			Total:       plan.Total,
+			Present:     plan.Present,
+			Reused:      plan.Reused,
+			Missing:     plan.Fetch,
+			Bytes:       plan.EstimatedBytes,
+			UpgradeFrom: plan.UpgradeFrom,
+		}
+		if event.Gid == "" {
+			event.Gid, _, _ = eh.ParseGalleryUrl(u)
+		}
+		switch {
+		case errors.Is(err, errAlreadyDownloaded):
+			event.Status, event.Reason = "skip", err.Error()
+			skipped++
+		case err != nil:
+			event.Status, event.Error = "error", err.Error()
+			errCount++
+			if exitCode(err) == exitBanned {
+				fatalErr = err
+			}
+		case len(plan.Fetch) == 0 && len(plan.Reused) == 0:
+			event.Status, event.Reason = "skip", i18n.T("图片已全部下载")
+			skipped++
+		default:
+			event.Status = "download"
+			planned++
+			images += len(plan.Fetch)
+			bytes += plan.EstimatedBytes
+			if len(plan.Fetch) > 0 && plan.Info.FileSize == 0 {
+				unknownSize++
+			}
+		}
+
+		if reporter != nil {
+			_ = reporter.Emit(event)
+		} else {
+			writePlanRow(table, event, plan)
+		}
+		if fatalErr != nil {
+			break
+		}
+	}
+
+	var err error
+	status := "ok"
+	if fatalErr != nil {
+		err = i18n.Errorf("已中止，剩余%d个gallery未获取信息：%w", len(tasks)-planned-skipped-errCount, fatalErr)
+		status = "aborted"
+	} else if errCount > 0 {
+		err = i18n.Errorf("有%d个画廊信息获取失败", errCount)
+		status = "failed"
+	}
+	if reporter == nil {
+		_ = table.Flush()
+		fmt.Println(i18n.Sprintf("共%d个gallery，%d个需要下载，%d个跳过，%d个出错；需下载%d张图片，预计%s",
+			len(tasks), planned, skipped, errCount, images, progress.FormatBytes(bytes)))
+		if unknownSize > 0 {
+			fmt.Println(i18n.Sprintf("其中%d个gallery的页面没有显示大小，未计入预计大小", unknownSize))
+		}
+		return err
+	}
+	summary := report.Event{
+		Type:    report.Summary,
+		Total:   len(tasks),
+		Images:  images,
+		Bytes:   bytes,
+		Skipped: skipped,
+		Failed:  errCount,
+		Status:  status,
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	_ = reporter.Emit(summary)
+	return err
+}
+
+// writePlanRow 把一个画廊的下载计划写为表格的一行
+func writePlanRow(table io.Writer, event report.Event, plan eh.Plan) {
+	title := event.Title
+	if title == "" {
+		title = event.URL
+	}
+	var status, size, detail string
+	switch event.Status {
+	case "download":
+		status = i18n.T("下载")
+		size = progress.FormatBytes(plan.EstimatedBytes)
+		if len(plan.Fetch) > 0 && plan.Info.FileSize == 0 {
+			size = "?"
+		}
+		detail = plan.Dir
+		if !plan.ExistingDir {
+			detail = i18n.Sprintf("%s（新建）", plan.Dir)
+		}
+		if plan.UpgradeFrom != "" {
+			detail += i18n.Sprintf("，从%s升级", plan.UpgradeFrom)
+		}
+	case "skip":
+		status, detail = i18n.T("跳过"), event.Reason
+	default:
+		status, detail = i18n.T("出错"), event.Error
+	}
+	present := "-"
+	if plan.Total > 0 {
+		present = fmt.Sprintf("%d/%d", len(plan.Present), plan.Total)
+	}
+	_, _ = fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", status, title, present,
+		orDash(utils.FormatRanges(plan.Reused)), orDash(utils.FormatRanges(plan.Fetch)), orDash(size), detail)
+}
+
+// orDash 空的表格单元格显示为-
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// downloadAction 下载参数、-u或-l中的画廊
+func downloadAction(c *cli.Context) error {
+	reporter := newReport(os.Stdout)
+	if reporter != nil {
+		defer stdoutToStderr()()
+	}
+	//只输出计划时不读写队列
+	entries, jobQueue, err := galleryEntries(c, !dryRun)
+	if err != nil {
+		return err
+	}
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+	var display *progress.Display
+	if !dryRun {
+		display = newProgress()
+	}
+	tasks, err := newTasks(c, entries, store, reporter, display)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return planDownloads(tasks, reporter)
+	}
+	return runDownloads(tasks, jobQueue, reporter, display)
+}
+
+// infoAction 获取画廊信息，默认以JSON输出，--save时写入画廊目录
+func infoAction(c *cli.Context) error {
+	entries, _, err := galleryEntries(c, false)
+	if err != nil {
+		return err
+	}
+	network, err := parseNetwork()
+	if err != nil {
+		return usage(err)
+	}
+	if !c.Bool("save") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		encoder.SetEscapeHTML(false)
+		for _, entry := range entries {
+			galleryInfo, err := eh.FetchGalleryInfo(entry.URL, network)
+			if err != nil {
+				return err
+			}
+			if err := encoder.Encode(galleryInfo); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	metadataWriters, err := metadata.LookupAll(metadataNames.Value())
+	if err != nil {
+		return usage(err)
+	}
+	layout, routes, err := parseLayout()
+	if err != nil {
+		return usage(err)
+	}
+	//只写信息文件，不检查也不记录下载历史
+	downloader := &GalleryDownloader{InfoJsonPath: infoJsonPath, Metadata: metadataWriters}
+	opts := eh.Options{OnlyInfo: true, Layout: layout, Routes: routes, Network: network}
+	errCount := 0
+	for _, entry := range entries {
+		dir := outputDir
+		if entry.Output != "" {
+			dir = entry.Output
+		}
+		if _, err := downloader.Download(dir, entry.URL, opts); err != nil {
+			failColor(os.Stderr, i18n.T("获取失败:"), err)
+			errCount++
+		}
+	}
+	if errCount > 0 {
+		return i18n.Errorf("有%d个画廊信息获取失败", errCount)
+	}
+	return nil
+}
+
+// galleryDirs 参数中的画廊目录，没有参数时为输出目录中的全部画廊目录
+func galleryDirs(c *cli.Context) ([]string, error) {
+	if c.NArg() > 0 {
+		return c.Args().Slice(), nil
+	}
+	dirs := eh.ListGalleryDirs(outputDir, infoJsonPath)
+	if len(dirs) == 0 {
+		return nil, i18n.Errorf("%s中没有画廊目录", outputDir)
+	}
+	return dirs, nil
+}
+
+// verifyAction 检查画廊目录中的图片是否齐全且完整
+func verifyAction(c *cli.Context) error {
+	dirs, err := galleryDirs(c)
+	if err != nil {
+		return err
+	}
+	filenameTemplate, err := parseFilenameTemplate()
+	if err != nil {
+		return usage(err)
+	}
+	badCount := 0
+	for _, dir := range dirs {
+		report, err := eh.VerifyGallery(dir, infoJsonPath, filenameTemplate)
+		if err != nil {
+			failColor(os.Stderr, dir, err)
+			badCount++
+			continue
+		}
+		if report.OK() {
+			successColor(os.Stdout, i18n.T("完整:"), dir)
+			continue
+		}
+		badCount++
+		failColor(os.Stdout, i18n.T("不完整:"), dir)
+		if !report.HasInfo {
+			fmt.Println(i18n.T("  缺少画廊信息文件"), infoJsonPath)
+		}
+		if len(report.Missing) > 0 {
+			fmt.Println(i18n.T("  缺失图片:"), report.Missing)
+		}
+		if len(report.Corrupt) > 0 {
+			fmt.Println(i18n.T("  损坏的图片:"), strings.Join(report.Corrupt, ", "))
+		}
+	}
+	if badCount > 0 {
+		return i18n.Errorf("%d个画廊中有%d个不完整", len(dirs), badCount)
+	}
+	return nil
+}
+
+// retryAction 重新下载列表文件中失败的画廊，--save-list时只把失败的网址导出为新的列表文件
+func retryAction(c *cli.Context) error {
+	if listFilePath == "" || listFilePath == listfile.Stdin {
+		return usage(i18n.Errorf("请用--list指定列表文件"))
+	}
+	savePath := c.String("save-list")
+	var reporter *report.Writer
+	if savePath == "" {
+		reporter = newReport(os.Stdout)
+	}
+	if reporter != nil {
+		defer stdoutToStderr()()
+	}
+	jobQueue, err := queue.Load(queue.PathForList(listFilePath))
+	if err != nil {
+		return err
+	}
+	failed := jobQueue.Failed()
+	var urls []string
+	for _, job := range failed {
+		fmt.Printf(i18n.T("%s\t尝试%d次\t%s\n"), job.URL, job.Attempts, job.Reason)
+		urls = append(urls, job.URL)
+	}
+	if len(urls) == 0 {
+		successColor(os.Stdout, i18n.T("没有下载失败的gallery"))
+		return nil
+	}
+
+	if savePath != "" {
+		if err := os.WriteFile(savePath, []byte(strings.Join(urls, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+		fmt.Printf(i18n.T("共%d个失败的url，已导出到%s\n"), len(urls), savePath)
+		return nil
+	}
+
+	//列表文件仍在时沿用其中为画廊指定的选项
+	entries, _ := listfile.Read(listFilePath)
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+	var display *progress.Display
+	if !dryRun {
+		display = newProgress()
+	}
+	tasks, err := newTasks(c, queuedEntries(urls, entries), store, reporter, display)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return planDownloads(tasks, reporter)
+	}
+	return runDownloads(tasks, jobQueue, reporter, display)
+}
+
+// importAction 从聊天记录、HTML书签、收藏页或Markdown等任意文件中找出画廊链接，按gid去重并显示汇总后
+// 追加到--list指定的文本列表文件，没有--list时把网址输出到stdout，以便通过管道交给download -l -
+func importAction(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return usage(i18n.Errorf("请指定要导入的文件"))
+	}
+	if listFilePath != "" && listfile.DetectFormat(listFilePath, nil) != listfile.Text {
+		return usage(i18n.Errorf("只能导入到文本格式的列表文件：%s", listFilePath))
+	}
+	//没有--list时stdout只输出网址，汇总等信息输出到stderr
+	messages := os.Stdout
+	if listFilePath == "" {
+		messages = os.Stderr
+	}
+	var found []string
+	for _, path := range c.Args().Slice() {
+		var data []byte
+		var err error
+		if path == listfile.Stdin {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		urls := eh.ExtractGalleryUrls(string(data))
+		_, _ = fmt.Fprintln(messages, i18n.Sprintf("%s：%d个画廊链接", path, len(urls)))
+		found = append(found, urls...)
+	}
+	unique := eh.UniqueByGid(found)
+
+	//列表中已有的画廊不再加入
+	var listData []byte
+	listed := make(map[string]bool)
+	if listFilePath != "" && utils.FileExists(listFilePath) {
+		var err error
+		if listData, err = os.ReadFile(listFilePath); err != nil {
+			return err
+		}
+		entries, err := listfile.Parse(listData, listfile.Text)
+		if err != nil {
+			return usage(i18n.Errorf("读取列表文件%s失败：%w", listFilePath, err))
+		}
+		for _, u := range eh.UniqueByGid(listfile.URLs(entries)) {
+			gid, _, _ := eh.ParseGalleryUrl(u)
+			listed[gid] = true
+		}
+	}
+	var added []string
+	for _, u := range unique {
+		if gid, _, _ := eh.ParseGalleryUrl(u); !listed[gid] {
+			added = append(added, u)
+		}
+	}
+	successColor(messages, i18n.Sprintf("共%d个链接，按gid去重后为%d个画廊，其中%d个已在列表中，%d个待加入",
+		len(found), len(unique), len(unique)-len(added), len(added)))
+	if dryRun {
+		for _, u := range added {
+			_, _ = fmt.Fprintln(messages, u)
+		}
+		return nil
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if listFilePath == "" {
+		for _, u := range added {
+			fmt.Println(u)
+		}
+		return nil
+	}
+
+	var content strings.Builder
+	if len(listData) > 0 && !strings.HasSuffix(string(listData), "\n") {
+		content.WriteString("\n")
+	}
+	content.WriteString("# " + i18n.Sprintf("%s从%s导入", time.Now().Format(time.DateTime), strings.Join(c.Args().Slice(), ", ")) + "\n")
+	for _, u := range added {
+		content.WriteString(u + "\n")
+	}
+	file, err := os.OpenFile(listFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(content.String()); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	successColor(messages, i18n.Sprintf("已加入%s，可用download -l %s下载", listFilePath, listFilePath))
+	return nil
+}
+
+// exportAction 将已下载的画廊目录转换格式或导出为指定格式，标题等信息取自目录中的画廊信息文件
+func exportAction(c *cli.Context) error {
+	convertOpts, err := parseExportOptions()
+	if err != nil {
+		return usage(err)
+	}
+	if len(formats.Value()) == 0 && !convertOpts.Enabled() {
+		return usage(i18n.Errorf("请用--format指定导出格式或用--convert指定转换格式"))
+	}
+	dirs, err := galleryDirs(c)
+	if err != nil {
+		return err
+	}
+	exportOpts := export.Options{Manga: manga, VolumePages: volumePages}
+	for _, dir := range dirs {
+		var galleryInfo eh.GalleryInfo
+		infoPath := filepath.Join(dir, infoJsonPath)
+		if utils.FileExists(infoPath) {
+			if err := utils.LoadCache(infoPath, &galleryInfo); err != nil {
+				return err
+			}
+		}
+		if convertOpts.Enabled() {
+			converted, err := convert.Gallery(dir, convertOpts)
+			if err != nil {
+				return i18n.Errorf("%s转换图片格式失败：%w", dir, err)
+			}
+			fmt.Println(i18n.T("转换格式的图片数量:"), converted)
+		}
+		for _, format := range formats.Value() {
+			paths, err := export.Export(format, dir, galleryInfo, exportOpts)
+			if err != nil {
+				return i18n.Errorf("%s导出%s失败：%w", dir, format, err)
+			}
+			fmt.Println(i18n.T("已导出:"), strings.Join(paths, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/queue"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestFinishJob_preview(t *testing.T) {
+	path := queue.PathForList(filepath.Join(t.TempDir(), "list.txt"))
+	urls := []string{"https://e-hentai.org/g/1111111/1111111111/"}
+	jobQueue, err := queue.Load(path)
+	assert.NoError(t, err)
+	assert.NoError(t, jobQueue.Sync(urls))
+
+	//预览成功后，同一列表真正下载时不应被当作已完成
+	task := galleryTask{URL: urls[0], Opts: eh.Options{Preview: true}}
+	assert.NoError(t, startJob(jobQueue, task))
+	assert.NoError(t, finishJob(jobQueue, task, nil))
+	jobQueue, err = queue.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, urls, jobQueue.Runnable())
+	assert.Equal(t, 0, jobQueue.Jobs[0].Attempts)
+
+	task.Opts.Preview = false
+	assert.NoError(t, startJob(jobQueue, task))
+	assert.NoError(t, finishJob(jobQueue, task, nil))
+	jobQueue, err = queue.Load(path)
+	assert.NoError(t, err)
+	assert.Empty(t, jobQueue.Runnable())
+}
+
+func TestGalleryUrlRegex(t *testing.T) {
+	assert.True(t, galleryUrlRegex.MatchString("https://exhentai.org/g/2569708/4bd9316841/"))
+	assert.False(t, galleryUrlRegex.MatchString("https://e-hentaiXorg/g/2569708/4bd9316841/"))
+}
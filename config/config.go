@@ -0,0 +1,125 @@
+package config
+
+import (
+	"EhDownloader/i18n"
+	"EhDownloader/utils"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileName 配置文件名，位于用户配置目录下的程序目录中
+const FileName = "config.yaml"
+
+// File 配置文件，包含多个命名的配置，未指定配置名时使用Default
+type File struct {
+	Default  string             `yaml:"default"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile 一组设置，键为命令行参数名(如output、cookie、parallelism)，值可以是字符串、数字、布尔值、列表或映射
+type Profile map[string]any
+
+// DefaultPath 返回配置文件的默认路径，Linux为$XDG_CONFIG_HOME/EhDownloader/config.yaml或~/.config/EhDownloader/config.yaml
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, utils.AppName, FileName), nil
+}
+
+// Load 读取配置文件，文件不存在时返回空配置
+func Load(path string) (*File, error) {
+	file := &File{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, i18n.Errorf("配置文件%s格式错误：%w", path, err)
+	}
+	return file, nil
+}
+
+// Profile 返回名为name的配置，name为空时使用默认配置，没有默认配置时返回nil
+func (f *File) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return nil, i18n.Errorf("配置文件中没有名为%s的配置，可用的配置：%s", name, strings.Join(f.Names(), ", "))
+	}
+	return profile, nil
+}
+
+// Names 返回按字母顺序排列的全部配置名
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Keys 返回按字母顺序排列的全部设置名
+func (p Profile) Keys() []string {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Values 将设置转换为命令行参数的值，列表对应可多次指定的参数，映射按"键: 值"转换并按键排列
+func (p Profile) Values(key string) ([]string, error) {
+	switch value := p[key].(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			switch item.(type) {
+			case []any, map[string]any, Profile:
+				return nil, i18n.Errorf("设置%s的列表中不能嵌套列表或映射", key)
+			}
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	case map[string]any:
+		return mapValues(value), nil
+	case Profile:
+		//yaml.v3把嵌套的映射解码为外层的Profile类型
+		return mapValues(value), nil
+	default:
+		return []string{fmt.Sprint(value)}, nil
+	}
+}
+
+// mapValues 将映射按键排列并转换为"键: 值"
+func mapValues(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(m))
+	for _, key := range keys {
+		values = append(values, fmt.Sprintf("%s: %v", key, m[key]))
+	}
+	return values
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	assert.NoError(t, os.WriteFile(path, []byte(`default: eh-anon
+profiles:
+  eh-anon:
+    output: /data/eh
+    parallelism: 3
+  ex-original:
+    cookie: "ipb_member_id=1; ipb_pass_hash=abc"
+    format: [cbz, pdf]
+    original-names: true
+  slow-proxy:
+    proxy: socks5://127.0.0.1:1080
+    delay: 5s
+    header:
+      User-Agent: test
+      Referer: https://e-hentai.org/
+`), 0644))
+
+	file, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eh-anon", "ex-original", "slow-proxy"}, file.Names())
+
+	profile, err := file.Profile("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"output", "parallelism"}, profile.Keys())
+	values, err := profile.Values("parallelism")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3"}, values)
+
+	profile, err = file.Profile("ex-original")
+	assert.NoError(t, err)
+	values, err = profile.Values("format")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cbz", "pdf"}, values)
+	values, err = profile.Values("original-names")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"true"}, values)
+
+	profile, err = file.Profile("slow-proxy")
+	assert.NoError(t, err)
+	values, err = profile.Values("header")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Referer: https://e-hentai.org/", "User-Agent: test"}, values)
+	values, err = profile.Values("delay")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"5s"}, values)
+
+	_, err = file.Profile("nope")
+	assert.ErrorContains(t, err, "eh-anon, ex-original, slow-proxy")
+}
+
+func TestLoad_missing(t *testing.T) {
+	file, err := Load(filepath.Join(t.TempDir(), FileName))
+	assert.NoError(t, err)
+	profile, err := file.Profile("")
+	assert.NoError(t, err)
+	assert.Nil(t, profile)
+}
@@ -0,0 +1,207 @@
+package convert
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/i18n"
+	"EhDownloader/utils"
+	"bytes"
+	"fmt"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+
+	DefaultQuality = 90
+)
+
+// Formats 支持转换到的格式
+var Formats = []string{FormatJPEG, FormatPNG}
+
+// Options 控制下载后的格式转换，Format为空表示不转换
+type Options struct {
+	Format  string
+	Quality int //转换为JPEG时的质量，1-100
+}
+
+// Enabled 是否需要转换
+func (o Options) Enabled() bool {
+	return o.Format != ""
+}
+
+// Validate 检查转换参数是否有效
+func (o Options) Validate() error {
+	if o.Format != "" && !slices.Contains(Formats, o.Format) {
+		return i18n.Errorf("不支持转换为%s，可选：%s", o.Format, strings.Join(Formats, ", "))
+	}
+	if o.Format == FormatJPEG && (o.Quality < 1 || o.Quality > 100) {
+		return i18n.Errorf("JPEG质量必须在1到100之间：%d", o.Quality)
+	}
+	return nil
+}
+
+// extension 转换后文件的扩展名
+func (o Options) extension() string {
+	if o.Format == FormatJPEG {
+		return ".jpg"
+	}
+	return "." + o.Format
+}
+
+// description 记录在manifest中的转换方式
+func (o Options) description(from string) string {
+	if o.Format == FormatJPEG {
+		return fmt.Sprintf("%s->%s q%d", from, o.Format, o.Quality)
+	}
+	return from + "->" + o.Format
+}
+
+// Gallery 将画廊目录中的图片转换为opts指定的格式，已是目标格式的图片与动态GIF保持不变。
+// 转换成功后删除原文件并在manifest中记录，返回转换的图片数量
+func Gallery(galleryDir string, opts Options) (int, error) {
+	if !opts.Enabled() {
+		return 0, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+	files, err := utils.ListImageFiles(galleryDir)
+	if err != nil {
+		return 0, err
+	}
+	manifest := eh.LoadManifest(galleryDir)
+
+	semaphore := make(chan struct{}, utils.Parallelism)
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+	converted := 0
+	for _, file := range files {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(file string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			target, from, err := convertFile(file, opts)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				log.Printf(i18n.T("转换图片%s出错：%v"), filepath.Base(file), err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			if target == "" {
+				return
+			}
+			converted++
+			name := filepath.Base(file)
+			//manifest中没有记录的图片(如旧版本下载的)只转换文件
+			meta, ok := manifest.Find(name)
+			if !ok {
+				return
+			}
+			meta.File = filepath.Base(target)
+			//多次转换时保留最初下载的文件名
+			if meta.ConvertedFrom == "" {
+				meta.ConvertedFrom = name
+			}
+			meta.Conversion = opts.description(from)
+			manifest.Add(meta)
+		}(file)
+	}
+	wg.Wait()
+
+	if converted > 0 {
+		if err := manifest.Save(galleryDir); err != nil {
+			return converted, err
+		}
+	}
+	return converted, firstErr
+}
+
+// convertFile 转换一张图片，返回转换后的路径与原格式，无需转换时返回空路径
+func convertFile(path string, opts Options) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+	if format == opts.Format {
+		return "", format, nil
+	}
+	target := strings.TrimSuffix(path, filepath.Ext(path)) + opts.extension()
+
+	var img image.Image
+	if format == "gif" {
+		animation, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return "", "", err
+		}
+		//动态GIF转换后会丢失动画，保持原样
+		if len(animation.Image) > 1 {
+			return "", format, nil
+		}
+		img = animation.Image[0]
+	} else if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+		return "", "", err
+	}
+
+	var buffer bytes.Buffer
+	switch opts.Format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buffer, flatten(img), &jpeg.Options{Quality: opts.Quality})
+	case FormatPNG:
+		err = png.Encode(&buffer, img)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	//先写临时文件再改名，中断时不会留下不完整的图片
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, buffer.Bytes(), 0644); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		_ = os.Remove(tmp)
+		return "", "", err
+	}
+	//扩展名与实际格式不符时目标可能就是原文件
+	if target != path {
+		if err := os.Remove(path); err != nil {
+			return "", "", err
+		}
+	}
+	return target, format, nil
+}
+
+// flatten 将带透明通道的图片叠加到白色背景上，JPEG不支持透明
+func flatten(img image.Image) image.Image {
+	switch img.ColorModel() {
+	case color.GrayModel, color.YCbCrModel, color.CMYKModel:
+		return img
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Over)
+	return rgba
+}
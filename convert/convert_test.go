@@ -0,0 +1,99 @@
+package convert
+
+import (
+	"EhDownloader/eh"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeImage(t *testing.T, path string, encode func(*bytes.Buffer, image.Image) error) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	var buffer bytes.Buffer
+	assert.NoError(t, encode(&buffer, img))
+	assert.NoError(t, os.WriteFile(path, buffer.Bytes(), 0644))
+}
+
+func writeGIF(t *testing.T, path string, frames int) {
+	animation := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		animation.Image = append(animation.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White}))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, gif.EncodeAll(f, animation))
+	assert.NoError(t, f.Close())
+}
+
+func encodePNG(b *bytes.Buffer, img image.Image) error {
+	return png.Encode(b, img)
+}
+
+func encodeJPEG(b *bytes.Buffer, img image.Image) error {
+	return jpeg.Encode(b, img, nil)
+}
+
+func TestOptions_Validate(t *testing.T) {
+	assert.NoError(t, Options{}.Validate())
+	assert.NoError(t, Options{Format: FormatPNG}.Validate())
+	assert.NoError(t, Options{Format: FormatJPEG, Quality: DefaultQuality}.Validate())
+	assert.Error(t, Options{Format: FormatJPEG}.Validate())
+	assert.Error(t, Options{Format: "webp"}.Validate())
+}
+
+func TestGallery(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, filepath.Join(dir, "1.png"), encodePNG)
+	writeImage(t, filepath.Join(dir, "2.jpg"), encodeJPEG)
+	writeGIF(t, filepath.Join(dir, "3.gif"), 1)
+	writeGIF(t, filepath.Join(dir, "4.gif"), 3)
+	manifest := eh.LoadManifest(dir)
+	manifest.Add(eh.ImageMeta{Index: 1, File: "1.png", OriginalName: "a.png"})
+	manifest.Add(eh.ImageMeta{Index: 2, File: "2.jpg", OriginalName: "b.jpg"})
+	assert.NoError(t, manifest.Save(dir))
+
+	converted, err := Gallery(dir, Options{Format: FormatJPEG, Quality: 80})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, converted)
+
+	//静态GIF与PNG转换为JPEG，动态GIF与已是JPEG的图片保持不变
+	for _, name := range []string{"1.jpg", "2.jpg", "3.jpg", "4.gif"} {
+		assert.FileExists(t, filepath.Join(dir, name))
+	}
+	for _, name := range []string{"1.png", "3.gif"} {
+		assert.NoFileExists(t, filepath.Join(dir, name))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "1.jpg"))
+	assert.NoError(t, err)
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+
+	images := eh.LoadManifest(dir).Images()
+	assert.Len(t, images, 2)
+	assert.Equal(t, "1.jpg", images[0].File)
+	assert.Equal(t, "1.png", images[0].ConvertedFrom)
+	assert.Equal(t, "png->jpeg q80", images[0].Conversion)
+	assert.Equal(t, "2.jpg", images[1].File)
+	assert.Empty(t, images[1].ConvertedFrom)
+
+	//再次转换为PNG时保留最初的文件名
+	converted, err = Gallery(dir, Options{Format: FormatPNG})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, converted)
+	images = eh.LoadManifest(dir).Images()
+	assert.Equal(t, "1.png", images[0].File)
+	assert.Equal(t, "1.png", images[0].ConvertedFrom)
+	assert.Equal(t, "jpeg->png", images[0].Conversion)
+	assert.Equal(t, "2.jpg", images[1].ConvertedFrom)
+	assert.FileExists(t, filepath.Join(dir, "4.gif"))
+}
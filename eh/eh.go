@@ -1,43 +1,260 @@
 package eh
 
 import (
+	"EhDownloader/i18n"
 	"EhDownloader/utils"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/carlmjohnson/requests"
 	"github.com/spf13/cast"
-	"github.com/ybbus/httpretry"
+	"io"
+	"io/fs"
 	"log"
 	"math"
 	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	chromeUserAgent = `Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36`
 	imageInOnePage  = 40
+	//重试轮次之间的基础等待时间，第n轮等待n*n倍
+	retryBackoffUnit = 5 * time.Second
 )
 
+var (
+	reloadKeyRegex    = regexp.MustCompile(`nl\('([^']+)'\)`)
+	galleryPathRegex  = regexp.MustCompile(`/g/(\d+)/([0-9a-f]{10})`)
+	imagePageRegex    = regexp.MustCompile(`/s/([0-9a-f]{10})/\d+-\d+`)
+	versionAddedRegex = regexp.MustCompile(`added (\d{4}-\d{2}-\d{2} \d{2}:\d{2})`)
+	fileSizeRegex     = regexp.MustCompile(`^([\d.]+)\s*(B|KiB|MiB|GiB|TiB)$`)
+)
+
+var (
+	// ErrBanned IP因请求过多被暂时封禁，继续请求只会延长封禁时间
+	ErrBanned = i18n.Error("IP已被E-Hentai暂时封禁")
+	// ErrQuotaExceeded 图片配额已用完，图片页返回的是509提示图
+	ErrQuotaExceeded = i18n.Error("图片配额已用完")
+)
+
+// 封禁时返回的页面内容开头
+const bannedPagePrefix = "Your IP address has been temporarily banned"
+
+// Options 控制单个画廊的下载行为
+type Options struct {
+	OnlyInfo    bool //只下载画廊信息
+	Preview     bool //只下载封面和缩略图，生成总览图，不下载原图
+	RetryRounds int  //主流程结束后重新下载缺失图片的轮数
+	//只下载选中的页，只获取这些页所在的目录页，续传和完整性检查也以选中的页为准，为空时下载全部
+	Pages   utils.PageSelection
+	Upgrade bool //画廊有更新版本时改为下载最新版本，并复用旧版本中相同的图片
+	//图片文件名模板，为nil时使用utils.DefaultFilenameTemplate
+	FilenameTemplate *utils.FilenameTemplate
+	//新建画廊目录时使用的目录模板，为nil时使用DefaultLayout
+	Layout *Layout
+	//按标签把画廊放到不同根目录的规则，按顺序匹配
+	Routes []RouteRule
+	//每张图片保存(或从旧版本复用)后调用，可能在多个goroutine中同时调用
+	ImageSaved func(galleryInfo GalleryInfo, galleryDir string, imagePath string)
+	//Cookie、代理、并发量等网络设置
+	Network Network
+	//已有图片数变化时调用，done包含之前已下载的图片
+	Progress func(galleryInfo GalleryInfo, done int, total int)
+	//图片获取或保存失败时调用，imagePageUrl为图片页的url
+	ImageFailed func(galleryInfo GalleryInfo, imagePageUrl string, err error)
+	//开始保存一张图片时调用，size为响应的Content-Length，未知时为-1。
+	//图片数据会同时写入返回的Writer，保存结束后关闭，用于显示传输进度
+	TrackImage func(galleryInfo GalleryInfo, imageTitle string, size int64) io.WriteCloser
+}
+
+// 输出目录中gid到画廊目录的索引，按输出目录缓存，避免每个画廊都重新扫描
+var (
+	dirIndexMutex sync.Mutex
+	dirIndexes    = make(map[string]map[string]string)
+)
+
+// scanGalleryDirs 递归扫描outputDir，根据画廊目录中画廊信息文件记录的gid建立索引，不再深入画廊目录内部
+func scanGalleryDirs(outputDir string, infoJsonPath string) map[string]string {
+	index := make(map[string]string)
+	_ = filepath.WalkDir(outputDir, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() || dir == outputDir {
+			return nil
+		}
+		infoPath := filepath.Join(dir, infoJsonPath)
+		if !utils.FileExists(infoPath) {
+			return nil
+		}
+		var info GalleryInfo
+		if err := utils.LoadCache(infoPath, &info); err == nil {
+			//旧版本的画廊信息中没有gid，从url中解析
+			if info.Gid == "" {
+				info.Gid, _, _ = ParseGalleryUrl(info.URL)
+			}
+			if info.Gid != "" {
+				index[info.Gid] = dir
+			}
+		}
+		return filepath.SkipDir
+	})
+	return index
+}
+
+// findGalleryDir 按gid查找outputDir中已有的画廊目录，不受目录重命名或画廊改标题的影响
+func findGalleryDir(outputDir string, infoJsonPath string, gid string) (string, bool) {
+	dirIndexMutex.Lock()
+	defer dirIndexMutex.Unlock()
+	index, ok := dirIndexes[outputDir]
+	if !ok {
+		index = scanGalleryDirs(outputDir, infoJsonPath)
+		dirIndexes[outputDir] = index
+	}
+	dir, found := index[gid]
+	return dir, found
+}
+
+// rememberGalleryDir 将新建的画廊目录加入索引
+func rememberGalleryDir(outputDir string, gid string, dir string) {
+	dirIndexMutex.Lock()
+	defer dirIndexMutex.Unlock()
+	if index, ok := dirIndexes[outputDir]; ok {
+		index[gid] = dir
+	}
+}
+
+// locateGalleryDir 先在路由规则指定的根目录中按gid查找已有的画廊目录，再到outputDir中查找，
+// 都没有时按目录模板在根目录下生成新的路径
+func locateGalleryDir(outputDir string, infoJsonPath string, opts Options, galleryInfo GalleryInfo) (string, bool) {
+	root := resolveRoot(opts.Routes, galleryInfo, outputDir)
+	if dir, found := findGalleryDir(root, infoJsonPath, galleryInfo.Gid); found {
+		return dir, true
+	}
+	if root != outputDir {
+		if dir, found := findGalleryDir(outputDir, infoJsonPath, galleryInfo.Gid); found {
+			return dir, true
+		}
+	}
+
+	layout := opts.Layout
+	if layout == nil {
+		layout = &Layout{raw: DefaultLayout, Policy: utils.DefaultSanitizePolicy}
+	}
+	return filepath.Join(root, layout.Render(galleryInfo)), false
+}
+
+// 画廊language标签到ISO 639-1代码的映射
+var languageCodes = map[string]string{
+	"japanese":   "ja",
+	"english":    "en",
+	"chinese":    "zh",
+	"korean":     "ko",
+	"french":     "fr",
+	"german":     "de",
+	"spanish":    "es",
+	"italian":    "it",
+	"portuguese": "pt",
+	"russian":    "ru",
+	"thai":       "th",
+	"vietnamese": "vi",
+	"indonesian": "id",
+	"polish":     "pl",
+	"dutch":      "nl",
+	"hungarian":  "hu",
+	"czech":      "cs",
+	"turkish":    "tr",
+	"arabic":     "ar",
+	"ukrainian":  "uk",
+}
+
+// LanguageISO 返回language标签中第一个可识别语言的ISO 639-1代码，忽略translated、rewrite等标记
+func LanguageISO(languages []string) string {
+	for _, language := range languages {
+		if code, ok := languageCodes[language]; ok {
+			return code
+		}
+	}
+	return ""
+}
+
+// NamespacedTags 返回除exclude以外所有命名空间的标签，格式为namespace:value，按字母顺序排列
+func (info GalleryInfo) NamespacedTags(exclude map[string]bool) []string {
+	var tags []string
+	for namespace, values := range info.TagList {
+		if exclude[namespace] {
+			continue
+		}
+		for _, value := range values {
+			tags = append(tags, namespace+":"+value)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Result 一次画廊下载的结果
+type Result struct {
+	Info    GalleryInfo
+	BaseDir string
+	Missing []int //下载结束后仍缺失的图片序号
+	Total   int   //要下载的图片数，选择了页时为选中的页数
+}
+
+// imageEvents 单张图片保存或失败时的回调
+type imageEvents struct {
+	saved  func(imageTitle string)
+	failed func(imagePageUrl string, err error)
+	track  func(imageTitle string, size int64) io.WriteCloser //为nil时不跟踪传输进度
+}
+
+// GalleryVersion 画廊页面上"There are newer versions of this gallery available"中列出的一个版本
+type GalleryVersion struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	Added string `json:"added"`
+}
+
 type GalleryInfo struct {
-	URL        string              `json:"gallery_url"`
-	Title      string              `json:"gallery_title"`
-	TotalImage int                 `json:"total_image"`
-	TagList    map[string][]string `json:"tag_list"`
+	Gid           string              `json:"gid"`
+	Token         string              `json:"token"`
+	URL           string              `json:"gallery_url"`
+	Title         string              `json:"gallery_title"`
+	TitleJpn      string              `json:"gallery_title_jpn,omitempty"`
+	Category      string              `json:"category,omitempty"`
+	Uploader      string              `json:"uploader,omitempty"`
+	Posted        string              `json:"posted,omitempty"` //发布时间，形如2023-01-01 10:00
+	TotalImage    int                 `json:"total_image"`
+	FileSize      int64               `json:"file_size,omitempty"` //画廊页上显示的总大小，单位为字节
+	TagList       map[string][]string `json:"tag_list"`
+	Parent        string              `json:"parent,omitempty"`         //上一个版本的画廊url
+	NewerVersions []GalleryVersion    `json:"newer_versions,omitempty"` //按时间顺序排列的更新版本
+	Pages         string              `json:"pages,omitempty"`          //下载时选择的页，如"1-20,35"，为空表示全部
+}
+
+// ParseGalleryUrl 从画廊url中解析出gid和token
+func ParseGalleryUrl(galleryUrl string) (gid string, token string, err error) {
+	match := galleryPathRegex.FindStringSubmatch(galleryUrl)
+	if match == nil {
+		return "", "", i18n.Errorf("无法从url中解析gid：%s", galleryUrl)
+	}
+	return match[1], match[2], nil
 }
 
 func generateIndexURL(urlStr string, page int) string {
 	u, err := url.Parse(urlStr)
 	if err != nil {
-		fmt.Println("Error parsing URL:", err)
+		fmt.Println(i18n.T("解析url出错:"), err)
 		return ""
 	}
 
@@ -73,10 +290,11 @@ func buildHtmlRequestHeaders() http.Header {
 	}
 }
 
-func getGalleryInfo(c *http.Client, galleryUrl string) GalleryInfo {
+func getGalleryInfo(c *http.Client, galleryUrl string) (GalleryInfo, error) {
 	var galleryInfo GalleryInfo
 	galleryInfo.TagList = make(map[string][]string)
 	galleryInfo.URL = galleryUrl
+	galleryInfo.Gid, galleryInfo.Token, _ = ParseGalleryUrl(galleryUrl)
 
 	var buffer bytes.Buffer
 	err := requests.URL(galleryUrl).
@@ -85,12 +303,15 @@ func getGalleryInfo(c *http.Client, galleryUrl string) GalleryInfo {
 		ToBytesBuffer(&buffer).
 		Fetch(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		return galleryInfo, err
+	}
+	if err := checkBanned(buffer.Bytes()); err != nil {
+		return galleryInfo, err
 	}
 
 	doc, err := goquery.NewDocumentFromReader(&buffer)
 	if err != nil {
-		log.Fatal(err)
+		return galleryInfo, err
 	}
 	galleryInfo.Title = doc.Find("h1#gn").Text()
 	pageText := doc.Find("#gdd > table > tbody > tr:nth-child(6) > td.gdt2").Text()
@@ -100,6 +321,31 @@ func getGalleryInfo(c *http.Client, galleryUrl string) GalleryInfo {
 		galleryInfo.TotalImage = cast.ToInt(reMaxPage.FindStringSubmatch(pageText)[1])
 	}
 
+	galleryInfo.TitleJpn = doc.Find("h1#gj").Text()
+	galleryInfo.Category = strings.TrimSpace(doc.Find("div#gdc div").First().Text())
+	galleryInfo.Uploader = strings.TrimSpace(doc.Find("div#gdn a").First().Text())
+
+	doc.Find("#gdd tr").Each(func(_ int, s *goquery.Selection) {
+		switch strings.TrimSpace(s.Find("td.gdt1").Text()) {
+		case "Parent:":
+			galleryInfo.Parent, _ = s.Find("td.gdt2 a").Attr("href")
+		case "Posted:":
+			galleryInfo.Posted = strings.TrimSpace(s.Find("td.gdt2").Text())
+		case "File Size:":
+			galleryInfo.FileSize = parseFileSize(s.Find("td.gdt2").Text())
+		}
+	})
+
+	added := versionAddedRegex.FindAllStringSubmatch(doc.Find("div#gnd").Text(), -1)
+	doc.Find("div#gnd a").Each(func(i int, s *goquery.Selection) {
+		version := GalleryVersion{Title: strings.TrimSpace(s.Text())}
+		version.URL, _ = s.Attr("href")
+		if i < len(added) {
+			version.Added = added[i][1]
+		}
+		galleryInfo.NewerVersions = append(galleryInfo.NewerVersions, version)
+	})
+
 	doc.Find("div#taglist table").Each(func(_ int, s *goquery.Selection) {
 		s.Find("tr").Each(func(_ int, s *goquery.Selection) {
 			key := strings.TrimSpace(s.Find("td.tc").Text())
@@ -111,25 +357,56 @@ func getGalleryInfo(c *http.Client, galleryUrl string) GalleryInfo {
 		})
 	})
 
-	return galleryInfo
+	return galleryInfo, nil
 }
 
-func getImagePageUrlList(c *http.Client, indexUrl string) []string {
-	var imagePageUrls []string
+// parseFileSize 解析形如"42.75 MiB"的大小，无法解析时返回0
+func parseFileSize(text string) int64 {
+	match := fileSizeRegex.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return 0
+	}
+	units := map[string]float64{"B": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40}
+	return int64(cast.ToFloat64(match[1]) * units[match[2]])
+}
+
+// checkBanned 判断页面是否为封禁提示
+func checkBanned(body []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte(bannedPagePrefix)) {
+		return fmt.Errorf("%w：%s", ErrBanned, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// isQuotaImage 判断图片url是否为配额用完时的509提示图
+func isQuotaImage(imageUrl string) bool {
+	return strings.HasSuffix(imageUrl, "/509.gif")
+}
+
+// fetchDocument 获取页面并解析为goquery文档
+func fetchDocument(c *http.Client, pageUrl string) (*goquery.Document, error) {
 	var buffer bytes.Buffer
 	err := requests.
-		URL(indexUrl).
+		URL(pageUrl).
 		Client(c).
 		UserAgent(chromeUserAgent).
 		ToBytesBuffer(&buffer).
 		Fetch(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if err := checkBanned(buffer.Bytes()); err != nil {
+		return nil, err
 	}
+	return goquery.NewDocumentFromReader(&buffer)
+}
 
-	doc, err := goquery.NewDocumentFromReader(&buffer)
+// fetchImagePageUrlList 获取目录页中所有图片页的url
+func fetchImagePageUrlList(c *http.Client, indexUrl string) ([]string, error) {
+	var imagePageUrls []string
+	doc, err := fetchDocument(c, indexUrl)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	doc.Find("div#gdt div.gdtm a").Each(func(_ int, s *goquery.Selection) {
@@ -137,28 +414,54 @@ func getImagePageUrlList(c *http.Client, indexUrl string) []string {
 		imagePageUrls = append(imagePageUrls, imgUrl)
 	})
 
-	return imagePageUrls
+	return imagePageUrls, nil
 }
 
-func getImageUrl(c *http.Client, imagePageUrl string) string {
-	var imageUrl string
-	var buffer bytes.Buffer
-	err := requests.
-		URL(imagePageUrl).
-		Client(c).
-		UserAgent(chromeUserAgent).
-		ToBytesBuffer(&buffer).
-		Fetch(context.Background())
+func getImageUrl(c *http.Client, imagePageUrl string) (string, ImageMeta, error) {
+	doc, err := fetchDocument(c, imagePageUrl)
 	if err != nil {
-		log.Fatal(err)
+		return "", ImageMeta{}, err
+	}
+	imageUrl, ok := doc.Find("img#img").Attr("src")
+	if !ok {
+		return "", ImageMeta{}, i18n.Errorf("图片页中未找到图片：%s", imagePageUrl)
+	}
+	if isQuotaImage(imageUrl) {
+		return "", ImageMeta{}, ErrQuotaExceeded
 	}
+	return imageUrl, parseImageMeta(doc), nil
+}
 
-	doc, err := goquery.NewDocumentFromReader(&buffer)
+// getReloadedImageUrl 模拟图片页上的"Reload broken image"，通过nl参数换一台图片服务器重新获取图片url
+func getReloadedImageUrl(c *http.Client, imagePageUrl string) (string, ImageMeta, error) {
+	doc, err := fetchDocument(c, imagePageUrl)
 	if err != nil {
-		log.Fatal(err)
+		return "", ImageMeta{}, err
 	}
-	imageUrl, _ = doc.Find("img#img").Attr("src")
-	return imageUrl
+
+	onclick, _ := doc.Find("a#loadfail").Attr("onclick")
+	if match := reloadKeyRegex.FindStringSubmatch(onclick); match != nil {
+		u, err := url.Parse(imagePageUrl)
+		if err != nil {
+			return "", ImageMeta{}, err
+		}
+		q := u.Query()
+		q.Set("nl", match[1])
+		u.RawQuery = q.Encode()
+		doc, err = fetchDocument(c, u.String())
+		if err != nil {
+			return "", ImageMeta{}, err
+		}
+	}
+
+	imageUrl, ok := doc.Find("img#img").Attr("src")
+	if !ok {
+		return "", ImageMeta{}, i18n.Errorf("图片页中未找到图片：%s", imagePageUrl)
+	}
+	if isQuotaImage(imageUrl) {
+		return "", ImageMeta{}, ErrQuotaExceeded
+	}
+	return imageUrl, parseImageMeta(doc), nil
 }
 
 func buildJPEGRequestHeaders() http.Header {
@@ -180,119 +483,496 @@ func buildJPEGRequestHeaders() http.Header {
 	}
 }
 
-func getImageInfoFromPage(c *http.Client, imagePageUrl string) (string, string) {
-	imageIndex := imagePageUrl[strings.LastIndex(imagePageUrl, "-")+1:]
-	imageUrl := getImageUrl(c, imagePageUrl)
-	imageSuffix := imageUrl[strings.LastIndex(imageUrl, "."):]
-	imageTitle := fmt.Sprintf("%s%s", imageIndex, imageSuffix)
-	return imageTitle, imageUrl
+// getImageHash 取出图片页url中的token，即图片文件SHA-1的前10位
+func getImageHash(imagePageUrl string) string {
+	match := imagePageRegex.FindStringSubmatch(imagePageUrl)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// getImageIndex 从图片页url(形如https://e-hentai.org/s/xxx/gid-index)中取出图片序号
+func getImageIndex(imagePageUrl string) string {
+	return imagePageUrl[strings.LastIndex(imagePageUrl, "-")+1:]
+}
+
+// buildImageTitle 按文件名模板生成图片的文件名，原始文件名优先取图片页上显示的，其次取图片url的最后一段
+func buildImageTitle(tmpl *utils.FilenameTemplate, galleryInfo GalleryInfo, imagePageUrl string, imageUrl string, originalName string) (string, error) {
+	if originalName == "" {
+		originalName = path.Base(imageUrl)
+		if u, err := url.Parse(imageUrl); err == nil {
+			originalName = path.Base(u.Path)
+		}
+	}
+	return tmpl.Render(utils.FilenameFields{
+		Index: cast.ToInt(getImageIndex(imagePageUrl)),
+		Total: galleryInfo.TotalImage,
+		Name:  strings.TrimSuffix(originalName, path.Ext(originalName)),
+		Hash:  getImageHash(imagePageUrl),
+		Gid:   galleryInfo.Gid,
+		Ext:   imageUrl[strings.LastIndex(imageUrl, ".")+1:],
+	})
+}
+
+// newImageMeta 补全图片页中解析出的信息
+func newImageMeta(meta ImageMeta, imagePageUrl string, imageTitle string) ImageMeta {
+	meta.Index = cast.ToInt(getImageIndex(imagePageUrl))
+	meta.File = imageTitle
+	meta.PageUrl = imagePageUrl
+	return meta
 }
 
-// SaveImageWithRequest 通过requests库更方便的保存imageInfo所指向的图片
-func SaveImageWithRequest(c *http.Client, h http.Header, imageInfo utils.ImageInfo, saveDir string) {
+func getImageInfoFromPage(c *http.Client, tmpl *utils.FilenameTemplate, galleryInfo GalleryInfo, imagePageUrl string) (string, string, ImageMeta, error) {
+	imageUrl, meta, err := getImageUrl(c, imagePageUrl)
+	if err != nil {
+		return "", "", ImageMeta{}, err
+	}
+	imageTitle, err := buildImageTitle(tmpl, galleryInfo, imagePageUrl, imageUrl, meta.OriginalName)
+	if err != nil {
+		return "", "", ImageMeta{}, err
+	}
+	return imageTitle, imageUrl, newImageMeta(meta, imagePageUrl, imageTitle), nil
+}
+
+// SaveImageWithRequest 通过requests库更方便的保存imageInfo所指向的图片，失败时返回错误
+func SaveImageWithRequest(c *http.Client, h http.Header, imageInfo utils.ImageInfo, saveDir string) error {
+	return saveImage(c, h, imageInfo, saveDir, nil)
+}
+
+// saveImage 保存图片，track不为nil时把收到的数据同时写入它返回的Writer，由调用方显示进度，不再逐张记录日志
+func saveImage(c *http.Client, h http.Header, imageInfo utils.ImageInfo, saveDir string, track func(string, int64) io.WriteCloser) error {
 	dir, _ := filepath.Abs(saveDir)
 	_ = os.MkdirAll(dir, os.ModePerm)
 	filePath, _ := filepath.Abs(filepath.Join(dir, imageInfo.Title))
 	err := requests.URL(imageInfo.Url).
 		Client(c).
-		ToFile(filePath).
 		Headers(h).
+		Handle(func(res *http.Response) error {
+			if track != nil {
+				tracker := track(imageInfo.Title, res.ContentLength)
+				defer tracker.Close()
+				res.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.TeeReader(res.Body, tracker), res.Body}
+			}
+			return requests.ToFile(filePath)(res)
+		}).
 		Fetch(context.Background())
 	if err != nil {
-		log.Printf("Error saving image: %s by error %v", imageInfo.Title, err)
-	} else {
-		log.Println("Image saved:", imageInfo.Title)
+		//删除可能残留的不完整文件，以免被当作已下载
+		_ = os.Remove(filePath)
+		log.Printf(i18n.T("保存图片%s出错：%v"), imageInfo.Title, err)
+		return err
+	}
+	if track == nil {
+		log.Println(i18n.T("图片已保存:"), imageInfo.Title)
+	}
+	return nil
+}
+
+// findImagePageUrls 只获取缺失图片所在的目录页，返回缺失图片的图片页url，按图片序号排列。
+// 获取目录页时被封禁则立即停止，返回已找到的url与该错误
+func findImagePageUrls(c *http.Client, galleryUrl string, imageIndexes []int, perPage int) ([]string, error) {
+	wanted := make(map[int]bool)
+	pageSet := make(map[int]bool)
+	var indexPages []int
+	for _, imageIndex := range imageIndexes {
+		wanted[imageIndex] = true
+		if page := (imageIndex - 1) / perPage; !pageSet[page] {
+			pageSet[page] = true
+			indexPages = append(indexPages, page)
+		}
+	}
+	sort.Ints(indexPages)
+
+	var pageUrls []string
+	for _, page := range indexPages {
+		imagePageUrlList, err := fetchImagePageUrlList(c, generateIndexURL(galleryUrl, page))
+		if err != nil {
+			log.Printf(i18n.T("获取第%d页目录出错：%v"), page, err)
+			if isFatal(err) {
+				return pageUrls, err
+			}
+			continue
+		}
+		for _, imagePageUrl := range imagePageUrlList {
+			if wanted[cast.ToInt(getImageIndex(imagePageUrl))] {
+				pageUrls = append(pageUrls, imagePageUrl)
+			}
+		}
+	}
+	sort.SliceStable(pageUrls, func(i, j int) bool {
+		return cast.ToInt(getImageIndex(pageUrls[i])) < cast.ToInt(getImageIndex(pageUrls[j]))
+	})
+	return pageUrls, nil
+}
+
+// isFatal 判断错误是否意味着继续请求已无意义，需要中止整个画廊
+func isFatal(err error) bool {
+	return errors.Is(err, ErrBanned) || errors.Is(err, ErrQuotaExceeded)
+}
+
+// retryMissingImages 多轮重新下载缺失的图片，每轮都换用备用服务器并逐轮增加等待时间，返回最终仍缺失的图片序号。
+// 被封禁或配额用完时立即停止并返回该错误
+func retryMissingImages(c *http.Client, tmpl *utils.FilenameTemplate, manifest *Manifest, galleryInfo GalleryInfo, baseDir string, pages []int, missingNumbers []int, rounds int, network Network, events imageEvents) ([]int, error) {
+	for round := 1; round <= rounds && len(missingNumbers) > 0; round++ {
+		backoff := time.Duration(round*round) * retryBackoffUnit
+		log.Printf(i18n.T("第%d/%d轮重试，%d张图片，等待%v"), round, rounds, len(missingNumbers), backoff)
+		time.Sleep(backoff)
+
+		imagePageUrls, err := findImagePageUrls(c, galleryInfo.URL, missingNumbers, network.thumbsPerPage())
+		if err != nil {
+			return missingNumbers, err
+		}
+		for _, imagePageUrl := range imagePageUrls {
+			imageIndex := cast.ToInt(getImageIndex(imagePageUrl))
+			imageUrl, meta, err := getReloadedImageUrl(c, imagePageUrl)
+			var imageTitle string
+			if err == nil {
+				imageTitle, err = buildImageTitle(tmpl, galleryInfo, imagePageUrl, imageUrl, meta.OriginalName)
+			}
+			if err != nil {
+				log.Printf(i18n.T("解析第%d张图片出错：%v"), imageIndex, err)
+				events.failed(imagePageUrl, err)
+				if isFatal(err) {
+					return missingNumbers, err
+				}
+				continue
+			}
+			imageInfo := utils.ImageInfo{
+				Title: imageTitle,
+				Url:   imageUrl,
+			}
+			if err := saveImage(c, buildJPEGRequestHeaders(), imageInfo, baseDir, events.track); err != nil {
+				events.failed(imagePageUrl, err)
+			} else {
+				manifest.Add(newImageMeta(meta, imagePageUrl, imageInfo.Title))
+				events.saved(imageInfo.Title)
+			}
+			time.Sleep(network.delay())
+		}
+
+		_, missingNumbers = utils.CheckFileNames(baseDir, pages, tmpl)
+	}
+	return missingNumbers, nil
+}
+
+// fetchAllImagePageUrls 依次获取画廊所有目录页，返回全部图片页的url
+func fetchAllImagePageUrls(c *http.Client, galleryInfo GalleryInfo, perPage int) ([]string, error) {
+	var imagePageUrls []string
+	sumPage := int(math.Ceil(float64(galleryInfo.TotalImage) / float64(perPage)))
+	for i := 0; i < sumPage; i++ {
+		pageUrls, err := fetchImagePageUrlList(c, generateIndexURL(galleryInfo.URL, i))
+		if err != nil {
+			return nil, err
+		}
+		imagePageUrls = append(imagePageUrls, pageUrls...)
+	}
+	return imagePageUrls, nil
+}
+
+// reusableImage 新版本中与旧版本相同、可以直接复制的一张图片
+type reusableImage struct {
+	pageUrl string    //新版本中的图片页url
+	title   string    //新版本中的文件名
+	oldMeta ImageMeta //旧版本中的图片信息，File为旧目录中的文件名
+}
+
+// findReusableImages 按图片页url中的SHA-1前缀匹配新旧版本中相同的图片，返回旧目录中已有文件的图片
+func findReusableImages(c *http.Client, tmpl *utils.FilenameTemplate, oldInfo GalleryInfo, oldDir string, newInfo GalleryInfo, perPage int) ([]reusableImage, error) {
+	oldPageUrls, err := fetchAllImagePageUrls(c, oldInfo, perPage)
+	if err != nil {
+		return nil, err
+	}
+	newPageUrls, err := fetchAllImagePageUrls(c, newInfo, perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	//旧目录中序号到文件名的映射
+	oldFiles := make(map[int]string)
+	entries, err := os.ReadDir(oldDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if index, ok := tmpl.Index(entry.Name()); ok {
+			oldFiles[index] = entry.Name()
+		}
+	}
+
+	//SHA-1前缀到旧文件的映射，旧版本的manifest中有原始文件名等信息
+	oldMetas := make(map[int]ImageMeta)
+	for _, meta := range LoadManifest(oldDir).Images() {
+		oldMetas[meta.Index] = meta
+	}
+	oldImages := make(map[string]ImageMeta)
+	for _, pageUrl := range oldPageUrls {
+		index := cast.ToInt(getImageIndex(pageUrl))
+		if name, ok := oldFiles[index]; ok {
+			meta := oldMetas[index]
+			meta.File = name
+			oldImages[getImageHash(pageUrl)] = meta
+		}
+	}
+
+	var images []reusableImage
+	for _, pageUrl := range newPageUrls {
+		hash := getImageHash(pageUrl)
+		oldMeta, ok := oldImages[hash]
+		if !ok {
+			continue
+		}
+		title, err := tmpl.Render(utils.FilenameFields{
+			Index: cast.ToInt(getImageIndex(pageUrl)),
+			Total: newInfo.TotalImage,
+			Name:  strings.TrimSuffix(oldMeta.OriginalName, filepath.Ext(oldMeta.OriginalName)),
+			Hash:  hash,
+			Gid:   newInfo.Gid,
+			Ext:   strings.TrimPrefix(filepath.Ext(oldMeta.File), "."),
+		})
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, reusableImage{pageUrl: pageUrl, title: title, oldMeta: oldMeta})
 	}
+	return images, nil
+}
 
+// reuseImagesFromOldVersion 将旧目录中与新版本相同、且在selected中的图片按新序号复制过来，返回复用的图片数量
+func reuseImagesFromOldVersion(c *http.Client, tmpl *utils.FilenameTemplate, manifest *Manifest, oldInfo GalleryInfo, oldDir string, newInfo GalleryInfo, newDir string, selected map[int]bool, perPage int, events imageEvents) (int, error) {
+	images, err := findReusableImages(c, tmpl, oldInfo, oldDir, newInfo, perPage)
+	if err != nil {
+		return 0, err
+	}
+	reused := 0
+	for _, image := range images {
+		target := filepath.Join(newDir, image.title)
+		if !selected[cast.ToInt(getImageIndex(image.pageUrl))] || utils.FileExists(target) {
+			continue
+		}
+		if err := utils.CopyFile(filepath.Join(oldDir, image.oldMeta.File), target); err != nil {
+			return reused, err
+		}
+		manifest.Add(newImageMeta(image.oldMeta, image.pageUrl, image.title))
+		events.saved(image.title)
+		reused++
+	}
+	return reused, nil
 }
 
-func DownloadGallery(outputDir string, infoJsonPath string, galleryUrl string, onlyInfo bool) error {
-	//目录号
-	beginIndex := 0
-	//余数
-	remainder := 0
+// selectPages 返回画廊中选中的页，并把选择记录到画廊信息中，选择的页都不在画廊中时返回错误
+func selectPages(galleryInfo *GalleryInfo, selection utils.PageSelection) ([]int, error) {
+	pages := selection.Pages(galleryInfo.TotalImage)
+	if len(selection) == 0 {
+		return pages, nil
+	}
+	if len(pages) == 0 {
+		return nil, i18n.Errorf("选择的页%s不在画廊的%d页之内", selection, galleryInfo.TotalImage)
+	}
+	galleryInfo.Pages = selection.String()
+	fmt.Println(i18n.Sprintf("选择的页：%s，共%d页", galleryInfo.Pages, len(pages)))
+	return pages, nil
+}
 
+// DownloadGallery 下载画廊到outputDir(或路由规则指定的根目录)下按目录模板生成的目录中，返回画廊信息与实际的保存目录
+func DownloadGallery(outputDir string, infoJsonPath string, galleryUrl string, opts Options) (Result, error) {
 	// create a new http client with retry
-	c := httpretry.NewDefaultClient(
-		// retry up to 5 times
-		httpretry.WithMaxRetryCount(5),
-		// retry on status >= 500, if err != nil, or if response was nil (status == 0)
-		httpretry.WithRetryPolicy(func(statusCode int, err error) bool {
-			return err != nil || statusCode >= 500 || statusCode == 0
-		}),
-		// every retry should wait one more second
-		httpretry.WithBackoffPolicy(func(attemptNum int) time.Duration {
-			return time.Duration(attemptNum+1) * 1 * time.Second
-		}),
-	)
+	c, err := opts.Network.newClient()
+	if err != nil {
+		return Result{}, err
+	}
+
+	tmpl := opts.FilenameTemplate
+	if tmpl == nil {
+		tmpl = utils.MustParseFilenameTemplate(utils.DefaultFilenameTemplate)
+	}
 
 	//获取画廊信息，快速判断网络联通情况
-	galleryInfo := getGalleryInfo(http.DefaultClient, galleryUrl)
-	fmt.Println("Total Image:", galleryInfo.TotalImage)
-	baseDir := filepath.Join(outputDir, utils.ToSafeFilename(galleryInfo.Title))
-	fmt.Println(baseDir)
+	galleryInfo, err := getGalleryInfo(c, galleryUrl)
+	if err != nil {
+		return Result{Info: galleryInfo}, err
+	}
 
-	//FIXME:处理此逻辑不应该通过检测数量的方法
-	//应该是先检查连续性，再从最后断开的地方开始下载
-	if utils.FileExists(filepath.Join(baseDir, infoJsonPath)) {
-		fmt.Println("发现下载记录")
-		success, missingNumbers := utils.CheckSequentialFileNames(baseDir, galleryInfo.TotalImage)
-		if success {
-			fmt.Println("本gallery已经下载完毕")
-			return nil
-		} else {
-			fmt.Println(missingNumbers)
-			//	TODO:重新下载缺失的图片
-			fmt.Println("剩余图片数量:", len(missingNumbers))
-			downloadedImageCount := galleryInfo.TotalImage - len(missingNumbers)
-			beginIndex = int(math.Floor(float64(downloadedImageCount) / float64(imageInOnePage)))
-			remainder = downloadedImageCount - imageInOnePage*beginIndex
+	//检查是否有更新的版本
+	var oldInfo *GalleryInfo
+	if len(galleryInfo.NewerVersions) > 0 {
+		fmt.Println(i18n.T("该画廊有更新的版本:"))
+		for _, v := range galleryInfo.NewerVersions {
+			fmt.Println(v.Added, v.Title, v.URL)
+		}
+		if opts.Upgrade {
+			newest := galleryInfo.NewerVersions[len(galleryInfo.NewerVersions)-1]
+			fmt.Println(i18n.T("升级到最新版本:"), newest.URL)
+			//先复制一份，之后galleryInfo会被新版本覆盖
+			old := galleryInfo
+			oldInfo = &old
+			galleryUrl = newest.URL
+			if galleryInfo, err = getGalleryInfo(c, galleryUrl); err != nil {
+				return Result{Info: galleryInfo}, err
+			}
 		}
+	}
 
+	fmt.Println(i18n.T("图片总数:"), galleryInfo.TotalImage)
+	pages, err := selectPages(&galleryInfo, opts.Pages)
+	if err != nil {
+		return Result{Info: galleryInfo}, err
+	}
+	baseDir, found := locateGalleryDir(outputDir, infoJsonPath, opts, galleryInfo)
+	if found {
+		fmt.Println(i18n.T("发现下载记录"))
 	} else {
-		//生成缓存文件
-		err := utils.BuildCache(baseDir, infoJsonPath, galleryInfo)
-		if err != nil {
-			return err
+		rememberGalleryDir(resolveRoot(opts.Routes, galleryInfo, outputDir), galleryInfo.Gid, baseDir)
+	}
+	fmt.Println(baseDir)
+	result := Result{Info: galleryInfo, BaseDir: baseDir, Total: len(pages)}
+
+	//生成缓存文件，已有目录也重新写入以更新标题等信息
+	if err := utils.BuildCache(baseDir, infoJsonPath, galleryInfo); err != nil {
+		return result, err
+	}
+
+	if opts.OnlyInfo {
+		fmt.Println(i18n.T("画廊信息获取完毕，程序自动退出。"))
+		return result, nil
+	}
+	if opts.Preview {
+		if err := downloadPreview(c, galleryInfo, baseDir, opts.Network); err != nil {
+			return result, err
+		}
+		fmt.Println(i18n.T("预览已保存:"), filepath.Join(baseDir, PreviewDirName))
+		return result, nil
+	}
+
+	//图片保存或失败后通知调用方，done在检查已有图片后才开始计数
+	var done atomic.Int64
+	events := imageEvents{
+		saved: func(imageTitle string) {
+			if opts.ImageSaved != nil {
+				opts.ImageSaved(galleryInfo, baseDir, filepath.Join(baseDir, imageTitle))
+			}
+			if opts.Progress != nil {
+				opts.Progress(galleryInfo, int(done.Add(1)), len(pages))
+			}
+		},
+		failed: func(imagePageUrl string, err error) {
+			if opts.ImageFailed != nil {
+				opts.ImageFailed(galleryInfo, imagePageUrl, err)
+			}
+		},
+	}
+	if opts.TrackImage != nil {
+		events.track = func(imageTitle string, size int64) io.WriteCloser {
+			return opts.TrackImage(galleryInfo, imageTitle, size)
 		}
 	}
 
-	if onlyInfo {
-		fmt.Println("画廊信息获取完毕，程序自动退出。")
-		return nil
+	selected := make(map[int]bool)
+	for _, page := range pages {
+		selected[page] = true
+	}
+	manifest := LoadManifest(baseDir)
+	if oldInfo != nil {
+		if oldDir, found := locateGalleryDir(outputDir, infoJsonPath, opts, *oldInfo); found {
+			//更新旧版本的画廊信息，记录新版本的链接
+			if err := utils.BuildCache(oldDir, infoJsonPath, *oldInfo); err != nil {
+				return result, err
+			}
+			reused, err := reuseImagesFromOldVersion(c, tmpl, manifest, *oldInfo, oldDir, galleryInfo, baseDir, selected, opts.Network.thumbsPerPage(), events)
+			if err != nil {
+				log.Printf(i18n.T("从%s复用图片出错：%v"), oldDir, err)
+			}
+			fmt.Println(i18n.T("从旧版本复用的图片数量:"), reused)
+		}
+	}
+
+	success, missingNumbers := utils.CheckFileNames(baseDir, pages, tmpl)
+	done.Store(int64(len(pages) - len(missingNumbers)))
+	if opts.Progress != nil {
+		opts.Progress(galleryInfo, int(done.Load()), len(pages))
+	}
+	if success {
+		fmt.Println(i18n.T("本gallery已经下载完毕"))
+		return result, nil
 	}
-	sumPage := int(math.Ceil(float64(galleryInfo.TotalImage) / float64(imageInOnePage)))
-	for i := beginIndex; i < sumPage; i++ {
-		fmt.Println("\nCurrent index:", i)
+	fmt.Println(i18n.T("剩余图片数量:"), len(missingNumbers))
+
+	//只处理包含缺失图片的目录页，并跳过目录页中已经存在的图片
+	perPage := opts.Network.thumbsPerPage()
+	missing := make(map[int]bool)
+	indexPages := make(map[int]bool)
+	for _, imageIndex := range missingNumbers {
+		missing[imageIndex] = true
+		indexPages[(imageIndex-1)/perPage] = true
+	}
+	sumPage := int(math.Ceil(float64(galleryInfo.TotalImage) / float64(perPage)))
+	//被封禁或配额用完后不再发起新的请求
+	//各图片的错误类型不同，不能用atomic.Value保存
+	var fatalErr atomic.Pointer[error]
+	for i := 0; i < sumPage && fatalErr.Load() == nil; i++ {
+		if !indexPages[i] {
+			continue
+		}
+		fmt.Println(i18n.T("\n当前目录页:"), i)
 		indexUrl := generateIndexURL(galleryUrl, i)
-		log.Printf("Current index url: %s", indexUrl)
-		imagePageUrlList := getImagePageUrlList(c, indexUrl)
-		if i == beginIndex {
-			//如果是第一次处理目录，需要去掉前面的余数
-			imagePageUrlList = imagePageUrlList[remainder:]
+		log.Printf(i18n.T("当前目录页url：%s"), indexUrl)
+		imagePageUrlList, err := fetchImagePageUrlList(c, indexUrl)
+		if err != nil {
+			log.Printf(i18n.T("获取第%d页目录出错：%v"), i, err)
+			if isFatal(err) {
+				fatalErr.Store(&err)
+			}
+			continue
 		}
 
 		// Use a buffered channel as a semaphore to limit the number of goroutines running simultaneously
-		semaphore := make(chan struct{}, utils.Parallelism)
+		semaphore := make(chan struct{}, opts.Network.parallelism())
 		var wg sync.WaitGroup
 		for _, imagePageUrl := range imagePageUrlList {
+			if fatalErr.Load() != nil {
+				break
+			}
+			if !missing[cast.ToInt(getImageIndex(imagePageUrl))] {
+				continue
+			}
 			wg.Add(1)
 			// Acquire a semaphore slot before starting the goroutine
 			semaphore <- struct{}{}
 			go func(imagePageUrl string) {
 				defer wg.Done()
 				defer func() { <-semaphore }()
-				imageTitle, imageUrl := getImageInfoFromPage(c, imagePageUrl)
+				imageTitle, imageUrl, meta, err := getImageInfoFromPage(c, tmpl, galleryInfo, imagePageUrl)
+				if err != nil {
+					log.Printf(i18n.T("解析图片%s出错：%v"), imagePageUrl, err)
+					events.failed(imagePageUrl, err)
+					if isFatal(err) {
+						fatalErr.CompareAndSwap(nil, &err)
+					}
+					return
+				}
 				imageInfo := utils.ImageInfo{
 					Title: imageTitle,
 					Url:   imageUrl,
 				}
-				SaveImageWithRequest(c, buildJPEGRequestHeaders(), imageInfo, baseDir)
+				if err := saveImage(c, buildJPEGRequestHeaders(), imageInfo, baseDir, events.track); err != nil {
+					events.failed(imagePageUrl, err)
+				} else {
+					//保存成功后才记录，避免manifest中出现并不存在的图片
+					manifest.Add(meta)
+					events.saved(imageInfo.Title)
+				}
 			}(imagePageUrl)
 
-			//防止被ban，每保存一篇目录中的所有图片就sleep 1-3 seconds
+			//防止被ban，每保存一篇目录中的所有图片就sleep 1-3 seconds，设置了等待时间时按设置
+			if opts.Network.Delay > 0 {
+				time.Sleep(opts.Network.Delay)
+				continue
+			}
 			sleepTime := rand.Float64()*1 + 2
-			log.Println("Sleep ", cast.ToString(sleepTime), " seconds...")
+			log.Printf(i18n.T("等待%s秒..."), cast.ToString(sleepTime))
 			time.Sleep(time.Duration(sleepTime) * time.Second)
 		}
 
@@ -301,12 +981,30 @@ func DownloadGallery(outputDir string, infoJsonPath string, galleryUrl string, o
 
 	}
 
-	success, missingNumbers := utils.CheckSequentialFileNames(baseDir, galleryInfo.TotalImage)
-	if success {
-		fmt.Println("图片下载完毕")
-	} else {
-		fmt.Println(missingNumbers)
-		//	TODO:重新下载缺失的图片
+	success, missingNumbers = utils.CheckFileNames(baseDir, pages, tmpl)
+	result.Missing = missingNumbers
+	if fatal := fatalErr.Load(); fatal != nil {
+		//中止前仍保存已下载图片的信息
+		if err := manifest.Save(baseDir); err != nil {
+			return result, err
+		}
+		return result, i18n.Errorf("下载中止，%d张图片缺失：%w", len(missingNumbers), *fatal)
 	}
-	return nil
+	var retryErr error
+	if !success {
+		fmt.Println(i18n.T("缺失图片:"), missingNumbers)
+		missingNumbers, retryErr = retryMissingImages(c, tmpl, manifest, galleryInfo, baseDir, pages, missingNumbers, opts.RetryRounds, opts.Network, events)
+		result.Missing = missingNumbers
+	}
+	if err := manifest.Save(baseDir); err != nil {
+		return result, err
+	}
+	if retryErr != nil {
+		return result, i18n.Errorf("重试中止，%d张图片缺失：%w", len(missingNumbers), retryErr)
+	}
+	if len(missingNumbers) > 0 {
+		return result, i18n.Errorf("重试%d轮后仍有%d张图片缺失：%v", opts.RetryRounds, len(missingNumbers), missingNumbers)
+	}
+	fmt.Println(i18n.T("图片下载完毕"))
+	return result, nil
 }
@@ -1,10 +1,27 @@
 package eh
 
 import (
+	"EhDownloader/utils"
+	"bytes"
 	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/spf13/cast"
 	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func Test_getGalleryInfo(t *testing.T) {
@@ -15,8 +32,11 @@ func Test_getGalleryInfo(t *testing.T) {
 		{
 			url: "https://e-hentai.org/g/2569708/4bd9316841/",
 			expectedGalleryInfo: GalleryInfo{
+				Gid:        "2569708",
+				Token:      "4bd9316841",
 				URL:        "https://e-hentai.org/g/2569708/4bd9316841/",
 				Title:      "[中信出版社] 流浪地球2电影制作手记 The Wandering Earth II FLIM HAND BOOK",
+				Category:   "Non-H",
 				TotalImage: 468,
 				TagList: map[string][]string{
 					"language": {"chinese"},
@@ -27,7 +47,8 @@ func Test_getGalleryInfo(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.url, func(t *testing.T) {
-			galleryInfo := getGalleryInfo(http.DefaultClient, tc.url)
+			galleryInfo, err := getGalleryInfo(http.DefaultClient, tc.url)
+			assert.NoError(t, err)
 			assert.Equal(t, tc.expectedGalleryInfo, galleryInfo)
 		})
 	}
@@ -80,8 +101,629 @@ func Test_getImagePageUrlList(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := getImagePageUrlList(http.DefaultClient, tt.args.indexUrl)[0:4]
-			assert.Equalf(t, tt.want, got, "getImagePageUrlList(%v)", tt.args.indexUrl)
+			got, err := fetchImagePageUrlList(http.DefaultClient, tt.args.indexUrl)
+			assert.NoError(t, err)
+			assert.Equalf(t, tt.want, got[0:4], "fetchImagePageUrlList(%v)", tt.args.indexUrl)
 		})
 	}
 }
+
+func Test_getReloadedImageUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nl") == "43893-470112" {
+			fmt.Fprint(w, `<img id="img" src="https://backup.hath.network/h/2.jpg">`)
+			return
+		}
+		fmt.Fprint(w, `<img id="img" src="https://broken.hath.network/h/2.jpg">
+<a href="#" id="loadfail" onclick="return nl('43893-470112')">Reload broken image</a>`)
+	}))
+	defer server.Close()
+
+	got, _, err := getReloadedImageUrl(server.Client(), server.URL+"/s/0196805342/2569708-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://backup.hath.network/h/2.jpg", got)
+}
+
+func Test_getGalleryInfo_details(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<h1 id="gn">Old Title</h1><h1 id="gj">旧标题</h1>
+<div id="gdc"><div class="cs ct2">Doujinshi</div></div>
+<div id="gdn"><a href="https://e-hentai.org/uploader/someone">someone</a></div>
+<div id="gdd"><table><tbody>
+<tr><td class="gdt1">Posted:</td><td class="gdt2">2023-01-01 10:00</td></tr>
+<tr><td class="gdt1">File Size:</td><td class="gdt2">42.75 MiB</td></tr>
+<tr><td class="gdt1">Parent:</td><td class="gdt2"><a href="https://e-hentai.org/g/1000000/aaaaaaaaaa/">1000000</a></td></tr>
+</tbody></table></div>
+<div id="gnd">There are newer versions of this gallery available:<br>
+<a href="https://e-hentai.org/g/3000000/bbbbbbbbbb/">Fixed Title</a>, added 2024-02-03 04:05<br>
+<a href="https://e-hentai.org/g/4000000/cccccccccc/">Fixed Title v2</a>, added 2024-05-06 07:08</div>`)
+	}))
+	defer server.Close()
+
+	galleryInfo, err := getGalleryInfo(server.Client(), server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "旧标题", galleryInfo.TitleJpn)
+	assert.Equal(t, "Doujinshi", galleryInfo.Category)
+	assert.Equal(t, "someone", galleryInfo.Uploader)
+	assert.Equal(t, "2023-01-01 10:00", galleryInfo.Posted)
+	assert.Equal(t, int64(44826624), galleryInfo.FileSize)
+	assert.Equal(t, "https://e-hentai.org/g/1000000/aaaaaaaaaa/", galleryInfo.Parent)
+	assert.Equal(t, []GalleryVersion{
+		{URL: "https://e-hentai.org/g/3000000/bbbbbbbbbb/", Title: "Fixed Title", Added: "2024-02-03 04:05"},
+		{URL: "https://e-hentai.org/g/4000000/cccccccccc/", Title: "Fixed Title v2", Added: "2024-05-06 07:08"},
+	}, galleryInfo.NewerVersions)
+}
+
+func Test_getImageHash(t *testing.T) {
+	assert.Equal(t, "0196805342", getImageHash("https://e-hentai.org/s/0196805342/2569708-2"))
+	assert.Equal(t, "", getImageHash("https://e-hentai.org/g/2569708/4bd9316841/"))
+}
+
+func Test_scanGalleryDirs(t *testing.T) {
+	outputDir := t.TempDir()
+	//旧版本的画廊信息只有url，新版本带有gid
+	assert.NoError(t, utils.BuildCache(filepath.Join(outputDir, "renamed by hand"), "galleryInfo.json",
+		GalleryInfo{URL: "https://e-hentai.org/g/2569708/4bd9316841/"}))
+	assert.NoError(t, utils.BuildCache(filepath.Join(outputDir, "Title [3000000]"), "galleryInfo.json",
+		GalleryInfo{Gid: "3000000", Token: "bbbbbbbbbb", URL: "https://e-hentai.org/g/3000000/bbbbbbbbbb/"}))
+	assert.NoError(t, os.MkdirAll(filepath.Join(outputDir, "not a gallery"), os.ModePerm))
+	//按目录模板生成的多级目录
+	assert.NoError(t, utils.BuildCache(filepath.Join(outputDir, "Manga", "artist", "Nested [4000000]"), "galleryInfo.json",
+		GalleryInfo{Gid: "4000000", Token: "cccccccccc", URL: "https://e-hentai.org/g/4000000/cccccccccc/"}))
+
+	assert.Equal(t, map[string]string{
+		"2569708": filepath.Join(outputDir, "renamed by hand"),
+		"3000000": filepath.Join(outputDir, "Title [3000000]"),
+		"4000000": filepath.Join(outputDir, "Manga", "artist", "Nested [4000000]"),
+	}, scanGalleryDirs(outputDir, "galleryInfo.json"))
+}
+
+func Test_parseImageMeta(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<div id="i2"><div class="sn">...</div>
+<div>scan_p007.png :: 1280 x 1800 :: 300.5 KiB</div></div>`))
+	assert.NoError(t, err)
+	assert.Equal(t, ImageMeta{OriginalName: "scan_p007.png", Width: 1280, Height: 1800, Size: "300.5 KiB"}, parseImageMeta(doc))
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := LoadManifest(dir)
+	manifest.Add(ImageMeta{Index: 2, File: "2.jpg"})
+	manifest.Add(ImageMeta{Index: 1, File: "1.jpg"})
+	assert.NoError(t, manifest.Save(dir))
+
+	assert.Equal(t, []ImageMeta{{Index: 1, File: "1.jpg"}, {Index: 2, File: "2.jpg"}}, LoadManifest(dir).Images())
+}
+
+func TestLayout(t *testing.T) {
+	info := GalleryInfo{
+		Gid:      "2569708",
+		Title:    "Title: Subtitle",
+		TitleJpn: "",
+		Category: "Manga",
+		Posted:   "2023-06-05 08:09",
+		TagList: map[string][]string{
+			"language": {"translated", "chinese"},
+			"group":    {"some circle"},
+		},
+	}
+	tests := []struct {
+		layout string
+		want   string
+	}{
+		{layout: DefaultLayout, want: "Title_ Subtitle"},
+		{layout: "{category}/{artist|group}/{title} [{gid}]", want: filepath.Join("Manga", "some circle", "Title_ Subtitle [2569708]")},
+		{layout: "{language}/{year}/{title_jpn|title}", want: filepath.Join("chinese", "2023", "Title_ Subtitle")},
+		{layout: "{parody}/{gid}", want: filepath.Join("Unknown", "2569708")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			layout, err := ParseLayout(tt.layout)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, layout.Render(info))
+		})
+	}
+
+	_, err := ParseLayout("{uploader}")
+	assert.Error(t, err)
+
+	//没有指定模板时同样清理并限制目录名的长度
+	info.Title = strings.Repeat("长标题", 50)
+	dir, found := locateGalleryDir(t.TempDir(), "galleryInfo.json", Options{}, info)
+	assert.False(t, found)
+	assert.LessOrEqual(t, len(filepath.Base(dir)), utils.DefaultSanitizePolicy.MaxBytes)
+}
+
+func TestRouteRule(t *testing.T) {
+	var rules []RouteRule
+	for _, raw := range []string{"language:chinese=/mnt/chinese", "category:Manga=/mnt/manga"} {
+		rule, err := ParseRouteRule(raw)
+		assert.NoError(t, err)
+		rules = append(rules, rule)
+	}
+	_, err := ParseRouteRule("chinese=/mnt/chinese")
+	assert.Error(t, err)
+
+	chinese := GalleryInfo{Category: "Manga", TagList: map[string][]string{"language": {"chinese"}}}
+	manga := GalleryInfo{Category: "Manga", TagList: map[string][]string{"language": {"english"}}}
+	other := GalleryInfo{Category: "Doujinshi"}
+	assert.Equal(t, "/mnt/chinese", resolveRoot(rules, chinese, "images"))
+	assert.Equal(t, "/mnt/manga", resolveRoot(rules, manga, "images"))
+	assert.Equal(t, "images", resolveRoot(rules, other, "images"))
+}
+
+func Test_parseThumbnails(t *testing.T) {
+	//旧版页面样式在链接外层，新版在链接内层
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<div id="gd1"><div style="width:250px; height:354px; background:transparent url(https://ehgt.org/aa/bb/cover_250.jpg) 0 0 no-repeat"></div></div>
+<div id="gdt">
+<div class="gdtm" style="height:149px"><div style="margin:1px auto 0; width:100px; height:143px; background:transparent url(https://ehgt.org/m/002569/2569708-00.jpg) -0px 0 no-repeat"><a href="https://e-hentai.org/s/0196805342/2569708-1"><img alt="01" src="https://ehgt.org/g/blank.gif"></a></div></div>
+<div class="gdtm" style="height:149px"><div style="margin:1px auto 0; width:100px; height:141px; background:transparent url(https://ehgt.org/m/002569/2569708-00.jpg) -100px 0 no-repeat"><a href="https://e-hentai.org/s/1a2b3c4d5e/2569708-2"><img alt="02" src="https://ehgt.org/g/blank.gif"></a></div></div>
+<a href="https://e-hentai.org/s/5e4d3c2b1a/2569708-3"><div title="Page 3: 003.jpg" style="width:100px;height:142px;background:transparent url(https://ehgt.org/m/002569/2569708-01.jpg) -200px 0 no-repeat"></div></a>
+</div>`))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ehgt.org/aa/bb/cover_250.jpg", parseCoverUrl(doc))
+	assert.Equal(t, []Thumbnail{
+		{Index: 1, PageUrl: "https://e-hentai.org/s/0196805342/2569708-1", SpriteUrl: "https://ehgt.org/m/002569/2569708-00.jpg", X: 0, Y: 0, Width: 100, Height: 143},
+		{Index: 2, PageUrl: "https://e-hentai.org/s/1a2b3c4d5e/2569708-2", SpriteUrl: "https://ehgt.org/m/002569/2569708-00.jpg", X: 100, Y: 0, Width: 100, Height: 141},
+		{Index: 3, PageUrl: "https://e-hentai.org/s/5e4d3c2b1a/2569708-3", SpriteUrl: "https://ehgt.org/m/002569/2569708-01.jpg", X: 200, Y: 0, Width: 100, Height: 142},
+	}, parseThumbnails(doc))
+}
+
+func Test_downloadPreview(t *testing.T) {
+	//雪碧图中两张缩略图分别为红色和蓝色
+	sprite := image.NewRGBA(image.Rect(0, 0, 20, 30))
+	draw.Draw(sprite, image.Rect(0, 0, 10, 30), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(sprite, image.Rect(10, 0, 20, 30), image.NewUniform(color.RGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+	var spriteData bytes.Buffer
+	assert.NoError(t, png.Encode(&spriteData, sprite))
+
+	var spriteRequests int
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sprite.png":
+			spriteRequests++
+			_, _ = w.Write(spriteData.Bytes())
+		case "/cover.png":
+			_, _ = w.Write(spriteData.Bytes())
+		default:
+			fmt.Fprintf(w, `<div id="gd1"><div style="background:transparent url(%[1]s/cover.png) 0 0 no-repeat"></div></div>
+<div id="gdt">
+<a href="%[1]s/s/0196805342/2569708-1"><div style="width:10px;height:30px;background:transparent url(%[1]s/sprite.png) -0px 0 no-repeat"></div></a>
+<a href="%[1]s/s/1a2b3c4d5e/2569708-2"><div style="width:10px;height:28px;background:transparent url(%[1]s/sprite.png) -10px 0 no-repeat"></div></a>
+</div>`, server.URL)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	galleryInfo := GalleryInfo{URL: server.URL + "/g/2569708/4bd9316841/", TotalImage: 2}
+	assert.NoError(t, downloadPreview(server.Client(), galleryInfo, dir, Network{Delay: time.Millisecond}))
+	assert.Equal(t, 1, spriteRequests)
+
+	previewDir := filepath.Join(dir, PreviewDirName)
+	for _, name := range []string{"cover.png", "1.jpg", "2.jpg", ContactSheetName} {
+		assert.FileExists(t, filepath.Join(previewDir, name))
+	}
+	f, err := os.Open(filepath.Join(previewDir, "2.jpg"))
+	assert.NoError(t, err)
+	defer f.Close()
+	thumb, err := jpeg.Decode(f)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 28), thumb.Bounds())
+	r, _, b, _ := thumb.At(5, 5).RGBA()
+	assert.Less(t, r, b)
+
+	f, err = os.Open(filepath.Join(previewDir, ContactSheetName))
+	assert.NoError(t, err)
+	defer f.Close()
+	sheet, err := jpeg.Decode(f)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 30), sheet.Bounds())
+}
+
+func TestVerifyGallery(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "gallery")
+	assert.NoError(t, utils.BuildCache(dir, "galleryInfo.json", GalleryInfo{Gid: "2569708", TotalImage: 3}))
+	var data bytes.Buffer
+	assert.NoError(t, png.Encode(&data, image.NewGray(image.Rect(0, 0, 4, 4))))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.png"), data.Bytes(), 0644))
+	//截断的图片
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2.png"), data.Bytes()[:data.Len()/2], 0644))
+
+	assert.Equal(t, []string{dir}, ListGalleryDirs(root, "galleryInfo.json"))
+	report, err := VerifyGallery(dir, "galleryInfo.json", nil)
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, []int{3}, report.Missing)
+	assert.Equal(t, []string{"2.png"}, report.Corrupt)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "2.png"), data.Bytes(), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "3.png"), data.Bytes(), 0644))
+	report, err = VerifyGallery(dir, "galleryInfo.json", nil)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+}
+
+func TestNetwork_newClient(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	network := Network{UserAgent: "test-agent", Cookie: "ipb_member_id=1", Headers: map[string]string{"Referer": "https://e-hentai.org/"}}
+	c, err := network.newClient()
+	assert.NoError(t, err)
+	_, err = fetchDocument(c, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-agent", got.Get("User-Agent"))
+	assert.Equal(t, "ipb_member_id=1", got.Get("Cookie"))
+	assert.Equal(t, "https://e-hentai.org/", got.Get("Referer"))
+
+	_, err = Network{Proxy: "not a proxy"}.newClient()
+	assert.Error(t, err)
+	assert.Equal(t, 40, Network{}.thumbsPerPage())
+}
+
+func TestBannedAndQuota(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/s/") {
+			fmt.Fprint(w, `<img id="img" src="https://ehgt.org/g/509.gif">`)
+			return
+		}
+		fmt.Fprint(w, "Your IP address has been temporarily banned for excessive pageloads. The ban expires in 59 minutes")
+	}))
+	defer server.Close()
+
+	_, err := getGalleryInfo(server.Client(), server.URL+"/g/2569708/25a1d9e5d6/")
+	assert.ErrorIs(t, err, ErrBanned)
+	assert.True(t, isFatal(err))
+
+	_, _, err = getImageUrl(server.Client(), server.URL+"/s/0196805342/2569708-2")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.False(t, isQuotaImage("https://abc.hath.network/h/2.jpg"))
+}
+
+func Test_parseFileSize(t *testing.T) {
+	assert.Equal(t, int64(44826624), parseFileSize(" 42.75 MiB"))
+	assert.Equal(t, int64(1536), parseFileSize("1.5 KiB"))
+	assert.Equal(t, int64(0), parseFileSize("unknown"))
+}
+
+// countingWriteCloser 记录写入的字节数与是否已关闭
+type countingWriteCloser struct {
+	n      int
+	closed bool
+}
+
+func (w *countingWriteCloser) Write(p []byte) (int, error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
+func (w *countingWriteCloser) Close() error {
+	w.closed = true
+	return nil
+}
+
+func Test_saveImage_track(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4096")
+		fmt.Fprint(w, strings.Repeat("x", 4096))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	tracker := &countingWriteCloser{}
+	var gotSize int64
+	err := saveImage(server.Client(), http.Header{}, utils.ImageInfo{Title: "001.jpg", Url: server.URL}, dir,
+		func(imageTitle string, size int64) io.WriteCloser {
+			gotSize = size
+			return tracker
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4096), gotSize)
+	assert.Equal(t, 4096, tracker.n)
+	assert.True(t, tracker.closed)
+	data, err := os.ReadFile(filepath.Join(dir, "001.jpg"))
+	assert.NoError(t, err)
+	assert.Len(t, data, 4096)
+}
+
+func TestPlanGallery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<h1 id="gn">Planned</h1>
+<div id="gdd"><table><tbody>
+<tr><td class="gdt1">Posted:</td><td class="gdt2">2024-01-01 10:00</td></tr>
+<tr><td class="gdt1">Parent:</td><td class="gdt2">None</td></tr>
+<tr><td class="gdt1">Visible:</td><td class="gdt2">Yes</td></tr>
+<tr><td class="gdt1">Language:</td><td class="gdt2">Japanese</td></tr>
+<tr><td class="gdt1">File Size:</td><td class="gdt2">10.00 KiB</td></tr>
+<tr><td class="gdt1">Length:</td><td class="gdt2">5 pages</td></tr>
+</tbody></table></div>`)
+	}))
+	defer server.Close()
+	galleryUrl := server.URL + "/g/5000000/dddddddddd/"
+	opts := Options{Layout: &Layout{raw: GidLayout}}
+
+	//目录不存在时全部需要下载
+	plan, err := PlanGallery(t.TempDir(), "galleryInfo.json", galleryUrl, opts)
+	assert.NoError(t, err)
+	assert.False(t, plan.ExistingDir)
+	assert.Equal(t, 5, plan.Info.TotalImage)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, plan.Fetch)
+	assert.Equal(t, int64(10240), plan.EstimatedBytes)
+
+	//已有的目录中继续下载，不创建任何文件
+	outputDir := t.TempDir()
+	galleryDir := filepath.Join(outputDir, "renamed")
+	assert.NoError(t, utils.BuildCache(galleryDir, "galleryInfo.json", GalleryInfo{Gid: "5000000", URL: galleryUrl}))
+	for _, name := range []string{"1.jpg", "2.png", "4.jpg"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(galleryDir, name), nil, 0644))
+	}
+	plan, err = PlanGallery(outputDir, "galleryInfo.json", galleryUrl, opts)
+	assert.NoError(t, err)
+	assert.True(t, plan.ExistingDir)
+	assert.Equal(t, galleryDir, plan.Dir)
+	assert.Equal(t, []int{1, 2, 4}, plan.Present)
+	assert.Equal(t, []int{3, 5}, plan.Fetch)
+	assert.Equal(t, int64(4096), plan.EstimatedBytes)
+	entries, err := os.ReadDir(outputDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	//选择了页时只计划选中的页
+	opts.Pages = utils.PageSelection{{Start: 2, End: 3}}
+	plan, err = PlanGallery(outputDir, "galleryInfo.json", galleryUrl, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, plan.Total)
+	assert.Equal(t, []int{2}, plan.Present)
+	assert.Equal(t, []int{3}, plan.Fetch)
+	opts.Pages = utils.PageSelection{{Start: 6}}
+	_, err = PlanGallery(outputDir, "galleryInfo.json", galleryUrl, opts)
+	assert.Error(t, err)
+}
+
+func TestDownloadGallery_pages(t *testing.T) {
+	var indexPages []string
+	var mu sync.Mutex
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/g/"):
+			mu.Lock()
+			indexPages = append(indexPages, r.URL.Query().Get("p"))
+			mu.Unlock()
+			fmt.Fprint(w, `<h1 id="gn">Anthology</h1><div id="gdd"><table><tbody>
+<tr><td></td></tr><tr><td></td></tr><tr><td></td></tr><tr><td></td></tr><tr><td></td></tr>
+<tr><td class="gdt1">Length:</td><td class="gdt2">50 pages</td></tr>
+</tbody></table></div><div id="gdt">`)
+			if r.URL.Query().Get("p") == "1" {
+				for i := 41; i <= 50; i++ {
+					fmt.Fprintf(w, `<div class="gdtm"><a href="%s/s/%010d/6000000-%d"></a></div>`, server.URL, i, i)
+				}
+			}
+			fmt.Fprint(w, `</div>`)
+		case strings.HasPrefix(r.URL.Path, "/s/"):
+			fmt.Fprintf(w, `<img id="img" src="%s/img/%s.jpg">`, server.URL, path.Base(r.URL.Path))
+		default:
+			fmt.Fprint(w, "image")
+		}
+	}))
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	opts := Options{
+		Pages:   utils.PageSelection{{Start: 45, End: 46}},
+		Layout:  &Layout{raw: GidLayout},
+		Network: Network{Delay: time.Millisecond},
+	}
+	result, err := DownloadGallery(outputDir, "galleryInfo.json", server.URL+"/g/6000000/eeeeeeeeee/", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Total)
+	assert.Empty(t, result.Missing)
+	//只获取画廊页和第45、46页所在的目录页
+	assert.Equal(t, []string{"", "1"}, indexPages)
+	files, err := utils.ListImageFiles(result.BaseDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(result.BaseDir, "45.jpg"), filepath.Join(result.BaseDir, "46.jpg")}, files)
+
+	//画廊信息中记录了选择的页，检查时只检查这些页
+	report, err := VerifyGallery(result.BaseDir, "galleryInfo.json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "45-46", report.Info.Pages)
+	assert.Empty(t, report.Missing)
+}
+
+func TestDownloadGallery_manifest(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/g/"):
+			fmt.Fprint(w, `<h1 id="gn">Broken</h1><div id="gdd"><table><tbody>
+<tr><td></td></tr><tr><td></td></tr><tr><td></td></tr><tr><td></td></tr><tr><td></td></tr>
+<tr><td class="gdt1">Length:</td><td class="gdt2">2 pages</td></tr>
+</tbody></table></div><div id="gdt">`)
+			for i := 1; i <= 2; i++ {
+				fmt.Fprintf(w, `<div class="gdtm"><a href="%s/s/%010d/7000000-%d"></a></div>`, server.URL, i, i)
+			}
+			fmt.Fprint(w, `</div>`)
+		case strings.HasPrefix(r.URL.Path, "/s/"):
+			fmt.Fprintf(w, `<img id="img" src="%s/img/%s.jpg">`, server.URL, path.Base(r.URL.Path))
+		case r.URL.Path == "/img/7000000-2.jpg":
+			http.NotFound(w, r)
+		default:
+			fmt.Fprint(w, "image")
+		}
+	}))
+	defer server.Close()
+
+	opts := Options{Layout: &Layout{raw: GidLayout}, Network: Network{Delay: time.Millisecond}}
+	result, err := DownloadGallery(t.TempDir(), "galleryInfo.json", server.URL+"/g/7000000/ffffffffff/", opts)
+	assert.Error(t, err)
+	assert.Equal(t, []int{2}, result.Missing)
+	//保存失败的图片不应出现在manifest中
+	images := LoadManifest(result.BaseDir).Images()
+	if assert.Len(t, images, 1) {
+		assert.Equal(t, 1, images[0].Index)
+		assert.Equal(t, "1.jpg", images[0].File)
+	}
+}
+
+func TestDownloadGallery_upgrade(t *testing.T) {
+	//新版本中第2张图片被替换，第1、3张与旧版本相同
+	hashes := map[string][]string{
+		"8000000": {"1111111111", "2222222222", "3333333333"},
+		"8000001": {"1111111111", "4444444444", "3333333333"},
+	}
+	var downloaded []string
+	var mu sync.Mutex
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/g/"):
+			gid := strings.Split(r.URL.Path, "/")[2]
+			fmt.Fprint(w, `<h1 id="gn">Upgraded</h1><div id="gdd"><table><tbody>
+<tr><td></td></tr><tr><td></td></tr><tr><td></td></tr><tr><td></td></tr><tr><td></td></tr>
+<tr><td class="gdt1">Length:</td><td class="gdt2">3 pages</td></tr>
+</tbody></table></div>`)
+			if gid == "8000000" {
+				fmt.Fprintf(w, `<div id="gnd"><a href="%s/g/8000001/bbbbbbbbbb/">Upgraded v2</a>, added 2024-02-03 04:05</div>`, server.URL)
+			}
+			fmt.Fprint(w, `<div id="gdt">`)
+			for i, hash := range hashes[gid] {
+				fmt.Fprintf(w, `<div class="gdtm"><a href="%s/s/%s/%s-%d"></a></div>`, server.URL, hash, gid, i+1)
+			}
+			fmt.Fprint(w, `</div>`)
+		case strings.HasPrefix(r.URL.Path, "/s/"):
+			fmt.Fprintf(w, `<img id="img" src="%s/img/%s.jpg">`, server.URL, path.Base(r.URL.Path))
+		default:
+			mu.Lock()
+			downloaded = append(downloaded, path.Base(r.URL.Path))
+			mu.Unlock()
+			fmt.Fprint(w, "new")
+		}
+	}))
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	oldUrl := server.URL + "/g/8000000/aaaaaaaaaa/"
+	oldDir := filepath.Join(outputDir, "old")
+	assert.NoError(t, utils.BuildCache(oldDir, "galleryInfo.json", GalleryInfo{Gid: "8000000", URL: oldUrl}))
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, os.WriteFile(filepath.Join(oldDir, fmt.Sprintf("%d.jpg", i)), []byte("old"), 0644))
+	}
+
+	opts := Options{Upgrade: true, Layout: &Layout{raw: GidLayout}, Network: Network{Delay: time.Millisecond}}
+	result, err := DownloadGallery(outputDir, "galleryInfo.json", oldUrl, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "8000001", result.Info.Gid)
+	assert.NotEqual(t, oldDir, result.BaseDir)
+	//只下载被替换的图片，其余从旧目录复制
+	assert.Equal(t, []string{"8000001-2.jpg"}, downloaded)
+	for i, want := range []string{"old", "new", "old"} {
+		data, err := os.ReadFile(filepath.Join(result.BaseDir, fmt.Sprintf("%d.jpg", i+1)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+	//旧目录中仍是旧版本的画廊信息
+	var oldInfo GalleryInfo
+	assert.NoError(t, utils.LoadCache(filepath.Join(oldDir, "galleryInfo.json"), &oldInfo))
+	assert.Equal(t, "8000000", oldInfo.Gid)
+}
+
+func TestDownloadGallery_bannedAndQuota(t *testing.T) {
+	//第1张图片配额用完，第2张图片遇到封禁页，两个错误同时出现时不应panic
+	banned := make(chan struct{})
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/g/"):
+			fmt.Fprint(w, `<h1 id="gn">Limited</h1><div id="gdd"><table><tbody>
+<tr><td></td></tr><tr><td></td></tr><tr><td></td></tr><tr><td></td></tr><tr><td></td></tr>
+<tr><td class="gdt1">Length:</td><td class="gdt2">2 pages</td></tr>
+</tbody></table></div><div id="gdt">`)
+			for i := 1; i <= 2; i++ {
+				fmt.Fprintf(w, `<div class="gdtm"><a href="%s/s/%010d/9000000-%d"></a></div>`, server.URL, i, i)
+			}
+			fmt.Fprint(w, `</div>`)
+		case strings.HasSuffix(r.URL.Path, "-1"):
+			select {
+			case <-banned:
+			case <-time.After(time.Second):
+			}
+			fmt.Fprint(w, `<img id="img" src="https://ehgt.org/g/509.gif">`)
+		default:
+			close(banned)
+			fmt.Fprint(w, "Your IP address has been temporarily banned for excessive pageloads. The ban expires in 59 minutes")
+		}
+	}))
+	defer server.Close()
+
+	opts := Options{Layout: &Layout{raw: GidLayout}, Network: Network{Delay: time.Millisecond}}
+	result, err := DownloadGallery(t.TempDir(), "galleryInfo.json", server.URL+"/g/9000000/aaaaaaaaaa/", opts)
+	assert.True(t, isFatal(err), err)
+	assert.Equal(t, []int{1, 2}, result.Missing)
+}
+
+func Test_findImagePageUrls(t *testing.T) {
+	var requested []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := cast.ToInt(r.URL.Query().Get("p"))
+		requested = append(requested, r.URL.Query().Get("p"))
+		if page == 3 {
+			fmt.Fprint(w, "Your IP address has been temporarily banned for excessive pageloads. The ban expires in 59 minutes")
+			return
+		}
+		fmt.Fprint(w, `<div id="gdt">`)
+		for i := page*2 + 1; i <= page*2+2; i++ {
+			fmt.Fprintf(w, `<div class="gdtm"><a href="%s/s/%010d/2569708-%d"></a></div>`, server.URL, i, i)
+		}
+		fmt.Fprint(w, `</div>`)
+	}))
+	defer server.Close()
+
+	//按图片序号排列，每个目录页只获取一次
+	urls, err := findImagePageUrls(server.Client(), server.URL+"/g/2569708/4bd9316841/", []int{6, 1, 5, 2}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		server.URL + "/s/0000000001/2569708-1",
+		server.URL + "/s/0000000002/2569708-2",
+		server.URL + "/s/0000000005/2569708-5",
+		server.URL + "/s/0000000006/2569708-6",
+	}, urls)
+	assert.Equal(t, []string{"", "2"}, requested)
+
+	//目录页被封禁时立即停止，不再请求后面的目录页
+	requested = nil
+	urls, err = findImagePageUrls(server.Client(), server.URL+"/g/2569708/4bd9316841/", []int{9, 7, 1}, 2)
+	assert.ErrorIs(t, err, ErrBanned)
+	assert.Equal(t, []string{server.URL + "/s/0000000001/2569708-1"}, urls)
+	assert.Equal(t, []string{"", "3"}, requested)
+}
+
+func TestExtractGalleryUrls(t *testing.T) {
+	text := `<DT><A HREF="https://e-hentai.org/g/1111111/1a2b3c4d5e/?p=2&amp;x=1">Bookmark</A>
+[note](https://exhentai.org/g/2222222/ABCDEF1234/) and a chat line: look at e-hentai.org/g/3333333/0011223344 lol
+{"link":"https:\/\/e-hentai.org\/g\/1111111\/1a2b3c4d5e\/"} redirect?u=https%3A%2F%2Fg.e-hentai.org%2Fg%2F4444444%2F5566778899%2F
+https://e-hentai.org/s/0196805342/2569708-2 is an image page, not a gallery
+https://notexhentai.org/g/5555555/5555555555/ www.xe-hentai.org/g/6666666/6666666666/ my-e-hentai.org/g/7777777/7777777777/
+https://e-hentai.org/g/8888888/88888888889/ has an 11 character token`
+	urls := ExtractGalleryUrls(text)
+	assert.Equal(t, []string{
+		"https://e-hentai.org/g/1111111/1a2b3c4d5e/",
+		"https://exhentai.org/g/2222222/abcdef1234/",
+		"https://e-hentai.org/g/3333333/0011223344/",
+		"https://e-hentai.org/g/1111111/1a2b3c4d5e/",
+		"https://e-hentai.org/g/4444444/5566778899/",
+	}, urls)
+	assert.Equal(t, []string{
+		"https://e-hentai.org/g/1111111/1a2b3c4d5e/",
+		"https://exhentai.org/g/2222222/abcdef1234/",
+		"https://e-hentai.org/g/3333333/0011223344/",
+		"https://e-hentai.org/g/4444444/5566778899/",
+	}, UniqueByGid(append(urls, "https://exhentai.org/g/3333333/0011223344/")))
+}
@@ -0,0 +1,51 @@
+package eh
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// galleryLinkRegex 文本中的画廊链接，协议与子域名可省略，如聊天记录中的e-hentai.org/g/123/abc.../。
+// 域名前不能紧跟字母、数字、点或-，以免匹配notexhentai.org等其他域名
+var galleryLinkRegex = regexp.MustCompile(`(?i)(?:^|[^a-z0-9.-])(?:https?://)?(?:[a-z0-9-]+\.)*(e-hentai|exhentai)\.org/g/(\d+)/([0-9a-f]{10})`)
+
+// 网页与JSON导出中常见的转义，还原后再查找链接
+var linkUnescaper = strings.NewReplacer(`\/`, "/", "%2F", "/", "%2f", "/", "%3A", ":", "%3a", ":")
+
+// ExtractGalleryUrls 找出文本中所有的E-Hentai与ExHentai画廊链接，按出现顺序返回，不去重。
+// 文本可以是聊天记录、HTML书签、保存的收藏页或Markdown，链接统一为https://域名/g/gid/token/的形式
+func ExtractGalleryUrls(text string) []string {
+	text = linkUnescaper.Replace(html.UnescapeString(text))
+	var urls []string
+	for _, match := range galleryLinkRegex.FindAllStringSubmatchIndex(text, -1) {
+		//token后还有十六进制字符时不是有效的链接，不截取其中的前10位。RE2不支持零宽断言，因此在这里检查
+		if end := match[1]; end < len(text) && isHexDigit(text[end]) {
+			continue
+		}
+		site, gid, token := text[match[2]:match[3]], text[match[4]:match[5]], text[match[6]:match[7]]
+		urls = append(urls, "https://"+strings.ToLower(site)+".org/g/"+gid+"/"+strings.ToLower(token)+"/")
+	}
+	return urls
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// UniqueByGid 按gid去重，保留每个画廊第一次出现的链接
+func UniqueByGid(urls []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, u := range urls {
+		gid, _, err := ParseGalleryUrl(u)
+		if err != nil {
+			gid = u
+		}
+		if !seen[gid] {
+			seen[gid] = true
+			unique = append(unique, u)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,163 @@
+package eh
+
+import (
+	"EhDownloader/i18n"
+	"EhDownloader/utils"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// DefaultLayout 与早期版本一致，以标题作为画廊目录名
+	DefaultLayout = "{title}"
+	// GidLayout 目录名带有gid，标题变动后仍可辨认
+	GidLayout = "{title} [{gid}]"
+	// 模板字段全部为空时使用的目录名
+	unknownLayoutValue = "Unknown"
+	// 渲染时标题字段的占位符，清理和截断时再替换为标题
+	titlePlaceholder = "\x00"
+)
+
+var layoutFieldRegex = regexp.MustCompile(`\{([\w|]+)}`)
+
+// 目录模板中可用的字段
+var layoutFields = map[string]func(info GalleryInfo) string{
+	"title":     func(info GalleryInfo) string { return info.Title },
+	"title_jpn": func(info GalleryInfo) string { return info.TitleJpn },
+	"category":  func(info GalleryInfo) string { return info.Category },
+	"artist":    func(info GalleryInfo) string { return firstTag(info, "artist") },
+	"group":     func(info GalleryInfo) string { return firstTag(info, "group") },
+	"parody":    func(info GalleryInfo) string { return firstTag(info, "parody") },
+	"language": func(info GalleryInfo) string {
+		for _, language := range info.TagList["language"] {
+			if language != "translated" && language != "rewrite" {
+				return language
+			}
+		}
+		return ""
+	},
+	"gid": func(info GalleryInfo) string { return info.Gid },
+	"year": func(info GalleryInfo) string {
+		if len(info.Posted) >= 4 {
+			return info.Posted[:4]
+		}
+		return ""
+	},
+}
+
+func firstTag(info GalleryInfo, namespace string) string {
+	if values := info.TagList[namespace]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Layout 画廊目录模板，如"{category}/{artist|group}/{title} [{gid}]"。
+// 以/分隔多级目录，{a|b}表示取第一个不为空的字段，全部为空时为Unknown
+type Layout struct {
+	raw    string
+	Policy utils.SanitizePolicy //生成目录名时使用的清理策略，超长时优先截断标题
+}
+
+// ParseLayout 解析目录模板并检查字段是否有效
+func ParseLayout(raw string) (*Layout, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, i18n.Errorf("目录模板不能为空")
+	}
+	for _, match := range layoutFieldRegex.FindAllStringSubmatch(raw, -1) {
+		for _, field := range strings.Split(match[1], "|") {
+			if _, ok := layoutFields[field]; !ok {
+				return nil, i18n.Errorf("目录模板中有未知的字段%s：%s", field, raw)
+			}
+		}
+	}
+	return &Layout{raw: raw, Policy: utils.DefaultSanitizePolicy}, nil
+}
+
+func (l *Layout) String() string {
+	return l.raw
+}
+
+// Render 生成画廊相对于根目录的路径，每一级目录名都按Policy清理，
+// 超长时只截断标题字段，保留gid等其余部分
+func (l *Layout) Render(info GalleryInfo) string {
+	var parts []string
+	for _, segment := range strings.Split(filepath.ToSlash(l.raw), "/") {
+		if segment == "" {
+			continue
+		}
+		var title string
+		titleFound := false
+		name := layoutFieldRegex.ReplaceAllStringFunc(segment, func(s string) string {
+			fields := strings.Split(s[1:len(s)-1], "|")
+			value := unknownLayoutValue
+			for _, field := range fields {
+				if v := strings.TrimSpace(layoutFields[field](info)); v != "" {
+					value = v
+					break
+				}
+			}
+			//第一个包含标题的字段作为可截断的部分
+			if !titleFound && (slices.Contains(fields, "title") || slices.Contains(fields, "title_jpn")) {
+				titleFound = true
+				title = value
+				return titlePlaceholder
+			}
+			return value
+		})
+		prefix, suffix, found := strings.Cut(name, titlePlaceholder)
+		if !found {
+			prefix, title, suffix = "", name, ""
+		}
+		part, err := l.Policy.Fit(prefix, title, suffix)
+		if err != nil {
+			//目录名不需要从中解析信息，标题以外的部分过长时整体截断
+			part, _ = l.Policy.Fit("", prefix+title+suffix, "")
+		}
+		parts = append(parts, part)
+	}
+	return filepath.Join(parts...)
+}
+
+// RouteRule 按标签把画廊放到不同的根目录，如language:chinese=/mnt/chinese。
+// category也可以作为命名空间使用，如category:Manga=/mnt/manga
+type RouteRule struct {
+	Namespace string
+	Value     string
+	Root      string
+}
+
+// ParseRouteRule 解析namespace:value=root形式的路由规则
+func ParseRouteRule(raw string) (RouteRule, error) {
+	condition, root, ok := strings.Cut(raw, "=")
+	namespace, value, hasColon := strings.Cut(condition, ":")
+	if !ok || !hasColon || namespace == "" || value == "" || root == "" {
+		return RouteRule{}, i18n.Errorf("无效的路由规则，应为namespace:value=目录：%s", raw)
+	}
+	return RouteRule{Namespace: namespace, Value: value, Root: root}, nil
+}
+
+// Match 判断画廊是否符合该规则
+func (r RouteRule) Match(info GalleryInfo) bool {
+	if r.Namespace == "category" {
+		return strings.EqualFold(info.Category, r.Value)
+	}
+	for _, value := range info.TagList[r.Namespace] {
+		if value == r.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRoot 返回第一条匹配规则的根目录，都不匹配时为defaultRoot
+func resolveRoot(rules []RouteRule, info GalleryInfo, defaultRoot string) string {
+	for _, rule := range rules {
+		if rule.Match(info) {
+			return rule.Root
+		}
+	}
+	return defaultRoot
+}
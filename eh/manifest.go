@@ -0,0 +1,105 @@
+package eh
+
+import (
+	"EhDownloader/utils"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/spf13/cast"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ManifestFileName 画廊目录中记录每张图片信息的文件
+const ManifestFileName = "manifest.json"
+
+// 图片页上显示原始文件信息的一行，形如"xxx.jpg :: 1280 x 1800 :: 300.5 KiB"
+var imageMetaRegex = regexp.MustCompile(`^(.+?) :: (\d+) x (\d+) :: ([\d.]+ [KMG]i?B)$`)
+
+// ImageMeta 一张图片的原始信息与保存位置
+type ImageMeta struct {
+	Index         int    `json:"index"`
+	File          string `json:"file"`          //保存在画廊目录中的文件名
+	OriginalName  string `json:"original_name"` //上传时的原始文件名
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Size          string `json:"size"` //图片页上显示的原始文件大小，如300.5 KiB
+	PageUrl       string `json:"page_url"`
+	ConvertedFrom string `json:"converted_from,omitempty"` //下载后转换过格式时，转换前的文件名
+	Conversion    string `json:"conversion,omitempty"`     //转换的方式，如webp->jpeg q90
+}
+
+// parseImageMeta 从图片页中解析原始文件名、尺寸与大小
+func parseImageMeta(doc *goquery.Document) ImageMeta {
+	var meta ImageMeta
+	doc.Find("div#i2 div, div#i4 div").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		match := imageMetaRegex.FindStringSubmatch(strings.TrimSpace(s.Text()))
+		if match == nil {
+			return true
+		}
+		meta.OriginalName = match[1]
+		meta.Width = cast.ToInt(match[2])
+		meta.Height = cast.ToInt(match[3])
+		meta.Size = match[4]
+		return false
+	})
+	return meta
+}
+
+// Manifest 画廊中每张图片的信息，下载过程中并发写入，保存为按序号排列的列表
+type Manifest struct {
+	mutex  sync.Mutex
+	images map[int]ImageMeta
+}
+
+// LoadManifest 读取画廊目录中的manifest，不存在时返回空的manifest
+func LoadManifest(galleryDir string) *Manifest {
+	manifest := &Manifest{images: make(map[int]ImageMeta)}
+	var images []ImageMeta
+	path := filepath.Join(galleryDir, ManifestFileName)
+	if utils.FileExists(path) && utils.LoadCache(path, &images) == nil {
+		for _, image := range images {
+			manifest.images[image.Index] = image
+		}
+	}
+	return manifest
+}
+
+// Add 记录一张图片的信息，同一序号的旧记录会被覆盖
+func (m *Manifest) Add(meta ImageMeta) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.images[meta.Index] = meta
+}
+
+// Images 返回按序号排列的全部图片信息
+func (m *Manifest) Images() []ImageMeta {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	images := make([]ImageMeta, 0, len(m.images))
+	for _, image := range m.images {
+		images = append(images, image)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Index < images[j].Index
+	})
+	return images
+}
+
+// Find 按保存的文件名查找图片信息
+func (m *Manifest) Find(file string) (ImageMeta, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, image := range m.images {
+		if image.File == file {
+			return image, true
+		}
+	}
+	return ImageMeta{}, false
+}
+
+// Save 将manifest写入画廊目录
+func (m *Manifest) Save(galleryDir string) error {
+	return utils.BuildCache(galleryDir, ManifestFileName, m.Images())
+}
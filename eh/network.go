@@ -0,0 +1,87 @@
+package eh
+
+import (
+	"EhDownloader/i18n"
+	"EhDownloader/utils"
+	"github.com/ybbus/httpretry"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Network 访问E-Hentai时的网络设置，零值字段使用默认值
+type Network struct {
+	UserAgent     string            //为空时使用内置的Chrome UA
+	Cookie        string            //登录后的Cookie，形如ipb_member_id=...; ipb_pass_hash=...
+	Headers       map[string]string //额外的请求头，覆盖内置的同名请求头
+	Proxy         string            //代理地址，支持http、https与socks5
+	Parallelism   int               //同时下载的图片数，默认为utils.Parallelism
+	Delay         time.Duration     //每张图片开始下载之间的等待时间，默认随机等待2-3秒，重试时为utils.DelayMs毫秒
+	ThumbsPerPage int               //目录页每页的缩略图数，需与网站上的设置一致，默认为40
+}
+
+func (n Network) parallelism() int {
+	if n.Parallelism > 0 {
+		return n.Parallelism
+	}
+	return utils.Parallelism
+}
+
+func (n Network) delay() time.Duration {
+	if n.Delay > 0 {
+		return n.Delay
+	}
+	return time.Millisecond * time.Duration(utils.DelayMs)
+}
+
+func (n Network) thumbsPerPage() int {
+	if n.ThumbsPerPage > 0 {
+		return n.ThumbsPerPage
+	}
+	return imageInOnePage
+}
+
+// headerTransport 为每个请求加上设置中的UA、Cookie与额外请求头
+type headerTransport struct {
+	base    http.RoundTripper
+	network Network
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if t.network.UserAgent != "" {
+		req.Header.Set("User-Agent", t.network.UserAgent)
+	}
+	if t.network.Cookie != "" {
+		req.Header.Set("Cookie", t.network.Cookie)
+	}
+	for key, value := range t.network.Headers {
+		req.Header.Set(key, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newClient 按网络设置创建带重试的http客户端
+func (n Network) newClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if n.Proxy != "" {
+		proxyUrl, err := url.Parse(n.Proxy)
+		if err != nil || proxyUrl.Host == "" {
+			return nil, i18n.Errorf("代理地址无效：%s", n.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	return httpretry.NewCustomClient(
+		&http.Client{Transport: &headerTransport{base: transport, network: n}},
+		// retry up to 5 times
+		httpretry.WithMaxRetryCount(5),
+		// retry on status >= 500, if err != nil, or if response was nil (status == 0)
+		httpretry.WithRetryPolicy(func(statusCode int, err error) bool {
+			return err != nil || statusCode >= 500 || statusCode == 0
+		}),
+		// every retry should wait one more second
+		httpretry.WithBackoffPolicy(func(attemptNum int) time.Duration {
+			return time.Duration(attemptNum+1) * 1 * time.Second
+		}),
+	), nil
+}
@@ -0,0 +1,96 @@
+package eh
+
+import (
+	"EhDownloader/utils"
+	"github.com/spf13/cast"
+	"sort"
+)
+
+// Plan 一个画廊的下载计划，由PlanGallery生成，不创建目录也不下载图片
+type Plan struct {
+	Info        GalleryInfo
+	Dir         string //画廊目录
+	ExistingDir bool   //目录已存在，会在其中继续下载
+	UpgradeFrom string //升级模式下原画廊的url，没有升级时为空
+	Total       int    //要下载的图片数，选择了页时为选中的页数
+	Present     []int  //目录中已有的图片序号
+	Reused      []int  //可以从旧版本目录复制的图片序号
+	Fetch       []int  //需要下载的图片序号
+	//按画廊总大小与需要下载的图片数估算的下载字节数，画廊页没有显示大小时为0
+	EstimatedBytes int64
+}
+
+// PlanGallery 获取画廊信息，按下载时的规则确定目录并检查已有的图片，生成下载计划。
+// 升级模式下还会获取新旧版本的目录页，以确定可以复用的图片
+func PlanGallery(outputDir string, infoJsonPath string, galleryUrl string, opts Options) (Plan, error) {
+	c, err := opts.Network.newClient()
+	if err != nil {
+		return Plan{}, err
+	}
+	tmpl := opts.FilenameTemplate
+	if tmpl == nil {
+		tmpl = utils.MustParseFilenameTemplate(utils.DefaultFilenameTemplate)
+	}
+
+	var plan Plan
+	if plan.Info, err = getGalleryInfo(c, galleryUrl); err != nil {
+		return plan, err
+	}
+	var oldInfo *GalleryInfo
+	if opts.Upgrade && len(plan.Info.NewerVersions) > 0 {
+		old := plan.Info
+		oldInfo = &old
+		plan.UpgradeFrom = old.URL
+		if plan.Info, err = getGalleryInfo(c, old.NewerVersions[len(old.NewerVersions)-1].URL); err != nil {
+			return plan, err
+		}
+	}
+	pages, err := selectPages(&plan.Info, opts.Pages)
+	if err != nil {
+		return plan, err
+	}
+	plan.Total = len(pages)
+	plan.Dir, plan.ExistingDir = locateGalleryDir(outputDir, infoJsonPath, opts, plan.Info)
+
+	missing := make(map[int]bool)
+	if plan.ExistingDir {
+		_, missingNumbers := utils.CheckFileNames(plan.Dir, pages, tmpl)
+		for _, i := range missingNumbers {
+			missing[i] = true
+		}
+	} else {
+		for _, i := range pages {
+			missing[i] = true
+		}
+	}
+	for _, i := range pages {
+		if !missing[i] {
+			plan.Present = append(plan.Present, i)
+		}
+	}
+
+	if oldInfo != nil && len(missing) > 0 {
+		if oldDir, found := locateGalleryDir(outputDir, infoJsonPath, opts, *oldInfo); found {
+			images, err := findReusableImages(c, tmpl, *oldInfo, oldDir, plan.Info, opts.Network.thumbsPerPage())
+			if err != nil {
+				return plan, err
+			}
+			for _, image := range images {
+				if index := cast.ToInt(getImageIndex(image.pageUrl)); missing[index] {
+					delete(missing, index)
+					plan.Reused = append(plan.Reused, index)
+				}
+			}
+		}
+	}
+
+	for i := range missing {
+		plan.Fetch = append(plan.Fetch, i)
+	}
+	sort.Ints(plan.Reused)
+	sort.Ints(plan.Fetch)
+	if plan.Info.TotalImage > 0 {
+		plan.EstimatedBytes = plan.Info.FileSize * int64(len(plan.Fetch)) / int64(plan.Info.TotalImage)
+	}
+	return plan, nil
+}
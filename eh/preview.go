@@ -0,0 +1,214 @@
+package eh
+
+import (
+	"EhDownloader/i18n"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/carlmjohnson/requests"
+	"github.com/spf13/cast"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PreviewDirName 预览模式下缩略图保存在画廊目录中的子目录
+	PreviewDirName = "preview"
+	// ContactSheetName 全部缩略图拼成的总览图
+	ContactSheetName = "contact_sheet.jpg"
+	contactSheetCols = 10
+	previewQuality   = 90
+)
+
+var (
+	cssUrlRegex      = regexp.MustCompile(`url\(['"]?([^'")]+)['"]?\)`)
+	cssPositionRegex = regexp.MustCompile(`url\([^)]*\)\s*(-?\d+)(?:px)?\s+(-?\d+)(?:px)?`)
+	cssWidthRegex    = regexp.MustCompile(`(?:^|[;\s])width:\s*(\d+)px`)
+	cssHeightRegex   = regexp.MustCompile(`(?:^|[;\s])height:\s*(\d+)px`)
+)
+
+// Thumbnail 目录页中一张图片的缩略图，多张缩略图拼在同一张雪碧图中，按偏移量截取
+type Thumbnail struct {
+	Index     int
+	PageUrl   string
+	SpriteUrl string
+	X         int //缩略图在雪碧图中的左上角坐标
+	Y         int
+	Width     int
+	Height    int
+}
+
+// parseThumbnailStyle 从形如"width:100px; height:142px; background:transparent url(...) -100px 0 no-repeat"的样式中解析缩略图位置
+func parseThumbnailStyle(style string) (Thumbnail, bool) {
+	var thumb Thumbnail
+	match := cssUrlRegex.FindStringSubmatch(style)
+	if match == nil {
+		return thumb, false
+	}
+	thumb.SpriteUrl = match[1]
+	if match := cssPositionRegex.FindStringSubmatch(style); match != nil {
+		thumb.X = -cast.ToInt(match[1])
+		thumb.Y = -cast.ToInt(match[2])
+	}
+	if match := cssWidthRegex.FindStringSubmatch(style); match != nil {
+		thumb.Width = cast.ToInt(match[1])
+	}
+	if match := cssHeightRegex.FindStringSubmatch(style); match != nil {
+		thumb.Height = cast.ToInt(match[1])
+	}
+	return thumb, true
+}
+
+// parseThumbnails 解析目录页中的缩略图，兼容样式写在链接外层(旧版)和链接内层(新版)两种页面
+func parseThumbnails(doc *goquery.Document) []Thumbnail {
+	var thumbs []Thumbnail
+	doc.Find("div#gdt a").Each(func(_ int, s *goquery.Selection) {
+		pageUrl, _ := s.Attr("href")
+		style := s.Find("div[style]").AttrOr("style", "")
+		if !strings.Contains(style, "url(") {
+			style = s.Parent().AttrOr("style", "")
+		}
+		thumb, ok := parseThumbnailStyle(style)
+		if !ok {
+			return
+		}
+		thumb.PageUrl = pageUrl
+		thumb.Index = cast.ToInt(getImageIndex(pageUrl))
+		thumbs = append(thumbs, thumb)
+	})
+	return thumbs
+}
+
+// parseCoverUrl 解析画廊页左侧封面的url
+func parseCoverUrl(doc *goquery.Document) string {
+	if match := cssUrlRegex.FindStringSubmatch(doc.Find("div#gd1 div").AttrOr("style", "")); match != nil {
+		return match[1]
+	}
+	return doc.Find("div#gd1 img").AttrOr("src", "")
+}
+
+// fetchImageBytes 下载一张图片到内存
+func fetchImageBytes(c *http.Client, imageUrl string) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := requests.URL(imageUrl).
+		Client(c).
+		Headers(buildJPEGRequestHeaders()).
+		ToBytesBuffer(&buffer).
+		Fetch(context.Background())
+	return buffer.Bytes(), err
+}
+
+// cropThumbnail 从雪碧图中截取一张缩略图，超出雪碧图的部分被裁掉
+func cropThumbnail(sprite image.Image, thumb Thumbnail) image.Image {
+	bounds := sprite.Bounds()
+	rect := image.Rect(thumb.X, thumb.Y, thumb.X+thumb.Width, thumb.Y+thumb.Height).Add(bounds.Min)
+	if thumb.Width == 0 || thumb.Height == 0 {
+		rect = bounds
+	}
+	rect = rect.Intersect(bounds)
+	cropped := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), sprite, rect.Min, draw.Src)
+	return cropped
+}
+
+// buildContactSheet 按每行contactSheetCols张把缩略图拼成一张图，格子大小取最大的缩略图
+func buildContactSheet(thumbs []image.Image) image.Image {
+	cellWidth, cellHeight := 1, 1
+	for _, thumb := range thumbs {
+		cellWidth = max(cellWidth, thumb.Bounds().Dx())
+		cellHeight = max(cellHeight, thumb.Bounds().Dy())
+	}
+	cols := min(contactSheetCols, max(len(thumbs), 1))
+	rows := int(math.Ceil(float64(len(thumbs)) / float64(cols)))
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*cellWidth, max(rows, 1)*cellHeight))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+	for i, thumb := range thumbs {
+		//缩略图在格子中水平居中、底部对齐
+		bounds := thumb.Bounds()
+		x := (i%cols)*cellWidth + (cellWidth-bounds.Dx())/2
+		y := (i/cols)*cellHeight + cellHeight - bounds.Dy()
+		draw.Draw(sheet, image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy()), thumb, bounds.Min, draw.Src)
+	}
+	return sheet
+}
+
+// saveJPEG 将图片编码为JPEG保存
+func saveJPEG(filePath string, img image.Image) error {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: previewQuality}); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, buffer.Bytes(), 0644)
+}
+
+// downloadPreview 只下载封面和目录页的缩略图雪碧图，切分为每页的缩略图并生成总览图，保存在画廊目录的preview子目录中
+func downloadPreview(c *http.Client, galleryInfo GalleryInfo, baseDir string, network Network) error {
+	previewDir := filepath.Join(baseDir, PreviewDirName)
+	if err := os.MkdirAll(previewDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	var thumbs []Thumbnail
+	sumPage := int(math.Ceil(float64(galleryInfo.TotalImage) / float64(network.thumbsPerPage())))
+	for i := 0; i < max(sumPage, 1); i++ {
+		doc, err := fetchDocument(c, generateIndexURL(galleryInfo.URL, i))
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			if coverUrl := parseCoverUrl(doc); coverUrl != "" {
+				data, err := fetchImageBytes(c, coverUrl)
+				if err != nil {
+					log.Printf(i18n.T("保存封面出错：%v"), err)
+				} else if err := os.WriteFile(filepath.Join(previewDir, "cover"+path.Ext(coverUrl)), data, 0644); err != nil {
+					return err
+				}
+			}
+		}
+		thumbs = append(thumbs, parseThumbnails(doc)...)
+		time.Sleep(network.delay())
+	}
+	if len(thumbs) == 0 {
+		return i18n.Errorf("目录页中没有找到缩略图")
+	}
+
+	//同一雪碧图只下载一次
+	sprites := make(map[string]image.Image)
+	width := len(strconv.Itoa(galleryInfo.TotalImage))
+	var images []image.Image
+	for _, thumb := range thumbs {
+		sprite, ok := sprites[thumb.SpriteUrl]
+		if !ok {
+			data, err := fetchImageBytes(c, thumb.SpriteUrl)
+			if err != nil {
+				return err
+			}
+			if sprite, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+				return i18n.Errorf("无法解码缩略图%s：%w", thumb.SpriteUrl, err)
+			}
+			sprites[thumb.SpriteUrl] = sprite
+		}
+		img := cropThumbnail(sprite, thumb)
+		if err := saveJPEG(filepath.Join(previewDir, fmt.Sprintf("%0*d.jpg", width, thumb.Index)), img); err != nil {
+			return err
+		}
+		images = append(images, img)
+	}
+	log.Printf(i18n.T("预览已保存：%d张缩略图，来自%d张雪碧图"), len(images), len(sprites))
+	return saveJPEG(filepath.Join(previewDir, ContactSheetName), buildContactSheet(images))
+}
@@ -0,0 +1,82 @@
+package eh
+
+import (
+	"EhDownloader/utils"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FetchGalleryInfo 按网络设置获取画廊信息，不创建目录也不下载图片
+func FetchGalleryInfo(galleryUrl string, network Network) (GalleryInfo, error) {
+	c, err := network.newClient()
+	if err != nil {
+		return GalleryInfo{}, err
+	}
+	return getGalleryInfo(c, galleryUrl)
+}
+
+// ListGalleryDirs 返回outputDir中所有含有画廊信息文件的画廊目录，按路径排列
+func ListGalleryDirs(outputDir string, infoJsonPath string) []string {
+	var dirs []string
+	for _, dir := range scanGalleryDirs(outputDir, infoJsonPath) {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// VerifyReport 一个画廊目录的检查结果
+type VerifyReport struct {
+	Dir     string
+	Info    GalleryInfo
+	HasInfo bool     //目录中有画廊信息文件，没有时无法检查缺失的图片
+	Missing []int    //缺失的图片序号
+	Corrupt []string //无法完整解码的图片文件名
+}
+
+// OK 画廊目录是否完整
+func (r VerifyReport) OK() bool {
+	return r.HasInfo && len(r.Missing) == 0 && len(r.Corrupt) == 0
+}
+
+// VerifyGallery 检查画廊目录中的图片是否齐全且能完整解码，tmpl为nil时按默认文件名模板解析序号
+func VerifyGallery(galleryDir string, infoJsonPath string, tmpl *utils.FilenameTemplate) (VerifyReport, error) {
+	report := VerifyReport{Dir: galleryDir}
+	infoPath := filepath.Join(galleryDir, infoJsonPath)
+	if utils.FileExists(infoPath) {
+		if err := utils.LoadCache(infoPath, &report.Info); err != nil {
+			return report, err
+		}
+		report.HasInfo = true
+		//下载时选择了部分页的画廊只检查选中的页
+		selection, err := utils.ParsePageSelection(report.Info.Pages)
+		if err != nil {
+			return report, err
+		}
+		_, report.Missing = utils.CheckFileNames(galleryDir, selection.Pages(report.Info.TotalImage), tmpl)
+	}
+
+	files, err := utils.ListImageFiles(galleryDir)
+	if err != nil {
+		return report, err
+	}
+	for _, file := range files {
+		if !imageDecodes(file) {
+			report.Corrupt = append(report.Corrupt, filepath.Base(file))
+		}
+	}
+	return report, nil
+}
+
+// imageDecodes 完整解码图片，下载中断留下的截断文件会解码失败
+func imageDecodes(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, _, err = image.Decode(f)
+	return err == nil
+}
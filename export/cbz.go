@@ -0,0 +1,134 @@
+package export
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/i18n"
+	"EhDownloader/utils"
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const comicInfoName = "ComicInfo.xml"
+
+// 已被其他字段使用、不再写入Tags的命名空间
+var comicInfoUsedNamespaces = map[string]bool{
+	"artist":   true,
+	"parody":   true,
+	"language": true,
+}
+
+// ComicInfo Komga、Kavita等阅读服务器识别的元数据文件，字段参考ComicInfo v2.0 schema
+type ComicInfo struct {
+	XMLName     xml.Name `xml:"ComicInfo"`
+	XmlnsXsi    string   `xml:"xmlns:xsi,attr"`
+	XmlnsXsd    string   `xml:"xmlns:xsd,attr"`
+	Title       string   `xml:"Title,omitempty"`
+	Series      string   `xml:"Series,omitempty"`
+	Volume      int      `xml:"Volume,omitempty"`
+	Writer      string   `xml:"Writer,omitempty"`
+	Tags        string   `xml:"Tags,omitempty"`
+	Web         string   `xml:"Web,omitempty"`
+	PageCount   int      `xml:"PageCount,omitempty"`
+	LanguageISO string   `xml:"LanguageISO,omitempty"`
+	Manga       string   `xml:"Manga,omitempty"`
+}
+
+// BuildComicInfo 根据画廊信息生成ComicInfo
+func BuildComicInfo(info eh.GalleryInfo, pageCount int, manga bool) ComicInfo {
+	comicInfo := ComicInfo{
+		XmlnsXsi:    "http://www.w3.org/2001/XMLSchema-instance",
+		XmlnsXsd:    "http://www.w3.org/2001/XMLSchema",
+		Title:       info.Title,
+		Series:      strings.Join(info.TagList["parody"], ", "),
+		Writer:      strings.Join(info.TagList["artist"], ", "),
+		Web:         info.URL,
+		PageCount:   pageCount,
+		LanguageISO: eh.LanguageISO(info.TagList["language"]),
+	}
+	if manga {
+		comicInfo.Manga = "YesAndRightToLeft"
+	}
+	comicInfo.Tags = strings.Join(info.NamespacedTags(comicInfoUsedNamespaces), ",")
+	return comicInfo
+}
+
+// WriteCBZ 将画廊目录中的图片按页码顺序打包为CBZ，保存在画廊目录旁边，返回生成的文件路径。
+// 页数超过VolumePages时按卷拆分为多个文件
+func WriteCBZ(galleryDir string, info eh.GalleryInfo, opts Options) ([]string, error) {
+	pages, err := utils.ListImageFiles(galleryDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, i18n.Errorf("目录中没有图片：%s", galleryDir)
+	}
+
+	base := filepath.Clean(galleryDir)
+	volumes := splitVolumes(pages, opts.VolumePages)
+	var paths []string
+	for i, volumePages := range volumes {
+		comicInfo := BuildComicInfo(info, len(volumePages), opts.Manga)
+		cbzPath := base + ".cbz"
+		if len(volumes) > 1 {
+			comicInfo.Volume = i + 1
+			cbzPath = fmt.Sprintf("%s Vol.%02d.cbz", base, i+1)
+		}
+		if err := writeCBZFile(cbzPath, volumePages, comicInfo); err != nil {
+			return paths, err
+		}
+		paths = append(paths, cbzPath)
+	}
+	return paths, nil
+}
+
+func splitVolumes(pages []string, volumePages int) [][]string {
+	if volumePages <= 0 || len(pages) <= volumePages {
+		return [][]string{pages}
+	}
+	var volumes [][]string
+	for start := 0; start < len(pages); start += volumePages {
+		end := min(start+volumePages, len(pages))
+		volumes = append(volumes, pages[start:end])
+	}
+	return volumes
+}
+
+func writeCBZFile(cbzPath string, pages []string, comicInfo ComicInfo) error {
+	return writeFileAtomic(cbzPath, func(w io.Writer) error {
+		return writeZip(w, pages, comicInfo)
+	})
+}
+
+func writeZip(w io.Writer, pages []string, comicInfo ComicInfo) error {
+	zw := zip.NewWriter(w)
+	width := len(fmt.Sprint(len(pages)))
+	for i, page := range pages {
+		//图片本身已经压缩过，直接存储
+		name := fmt.Sprintf("%0*d%s", width, i+1, strings.ToLower(filepath.Ext(page)))
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if err := copyFileTo(entry, page); err != nil {
+			return err
+		}
+	}
+
+	entry, err := zw.Create(comicInfoName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(entry, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(entry)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(comicInfo); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,273 @@
+package export
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/i18n"
+	"EhDownloader/utils"
+	"archive/zip"
+	"fmt"
+	_ "golang.org/x/image/webp"
+	"hash/crc32"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const epubMimetype = "application/epub+zip"
+
+// 画廊没有language标签时默认为日语
+const defaultEpubLanguage = "ja"
+
+// 作为dc:creator写入的命名空间，其余命名空间作为dc:subject
+var epubCreatorNamespaces = map[string]bool{
+	"artist":   true,
+	"group":    true,
+	"language": true,
+}
+
+var epubMediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// epubPage EPUB中的一页，每张图片对应一个固定版式的xhtml
+type epubPage struct {
+	ID        string
+	Image     string //OEBPS内的图片路径
+	XHTML     string //OEBPS内的页面路径
+	MediaType string
+	Width     int
+	Height    int
+	Source    string //画廊目录中的原始文件
+}
+
+type epubBook struct {
+	Identifier string
+	Title      string
+	Language   string
+	Creators   []string
+	Subjects   []string
+	Modified   string
+	RTL        bool
+	Pages      []epubPage
+}
+
+var epubFuncs = template.FuncMap{
+	"esc": utils.EscapeXML,
+	"inc": func(i int) int { return i + 1 },
+}
+
+var containerTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+var packageTemplate = template.Must(template.New("opf").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">{{esc .Identifier}}</dc:identifier>
+    <dc:title>{{esc .Title}}</dc:title>
+    <dc:language>{{esc .Language}}</dc:language>
+{{- range .Creators}}
+    <dc:creator>{{esc .}}</dc:creator>
+{{- end}}
+{{- range .Subjects}}
+    <dc:subject>{{esc .}}</dc:subject>
+{{- end}}
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">auto</meta>
+    <meta property="rendition:spread">none</meta>
+    <meta name="cover" content="{{(index .Pages 0).ID}}-img"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+{{- range $i, $page := .Pages}}
+    <item id="{{$page.ID}}-img" href="{{$page.Image}}" media-type="{{$page.MediaType}}"{{if eq $i 0}} properties="cover-image"{{end}}/>
+    <item id="{{$page.ID}}" href="{{$page.XHTML}}" media-type="application/xhtml+xml"/>
+{{- end}}
+  </manifest>
+  <spine{{if .RTL}} page-progression-direction="rtl"{{end}}>
+{{- range .Pages}}
+    <itemref idref="{{.ID}}"/>
+{{- end}}
+  </spine>
+</package>
+`))
+
+var navTemplate = template.Must(template.New("nav").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{esc .Language}}" xml:lang="{{esc .Language}}">
+<head><title>{{esc .Title}}</title></head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol>
+      <li><a href="{{(index .Pages 0).XHTML}}">{{esc .Title}}</a></li>
+    </ol>
+  </nav>
+  <nav epub:type="page-list" hidden="">
+    <ol>
+{{- range $i, $page := .Pages}}
+      <li><a href="{{$page.XHTML}}">{{inc $i}}</a></li>
+{{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+var pageTemplate = template.Must(template.New("page").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>{{.ID}}</title>
+  <meta name="viewport" content="width={{.Width}}, height={{.Height}}"/>
+  <style>html,body{margin:0;padding:0}img{display:block;width:{{.Width}}px;height:{{.Height}}px}</style>
+</head>
+<body><img src="../{{.Image}}" alt="{{.ID}}"/></body>
+</html>
+`))
+
+// WriteEPUB 将画廊目录中的图片按页码顺序生成EPUB 3固定版式电子书，保存在画廊目录旁边，返回生成的文件路径
+func WriteEPUB(galleryDir string, info eh.GalleryInfo, opts Options) (string, error) {
+	book, err := buildEpubBook(galleryDir, info, opts)
+	if err != nil {
+		return "", err
+	}
+	epubPath := filepath.Clean(galleryDir) + ".epub"
+	err = writeFileAtomic(epubPath, func(w io.Writer) error {
+		return writeEpub(w, book)
+	})
+	return epubPath, err
+}
+
+func buildEpubBook(galleryDir string, info eh.GalleryInfo, opts Options) (epubBook, error) {
+	book := epubBook{
+		Identifier: info.URL,
+		Title:      info.Title,
+		Language:   eh.LanguageISO(info.TagList["language"]),
+		Creators:   append(append([]string{}, info.TagList["artist"]...), info.TagList["group"]...),
+		Subjects:   info.NamespacedTags(epubCreatorNamespaces),
+		Modified:   time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		RTL:        opts.Manga,
+	}
+	if book.Identifier == "" {
+		book.Identifier = filepath.Base(galleryDir)
+	}
+	if book.Title == "" {
+		book.Title = filepath.Base(galleryDir)
+	}
+	if book.Language == "" {
+		book.Language = defaultEpubLanguage
+	}
+
+	sources, err := utils.ListImageFiles(galleryDir)
+	if err != nil {
+		return book, err
+	}
+	if len(sources) == 0 {
+		return book, i18n.Errorf("目录中没有图片：%s", galleryDir)
+	}
+	width := max(len(fmt.Sprint(len(sources))), 4)
+	for i, source := range sources {
+		config, err := decodeImageConfig(source)
+		if err != nil {
+			return book, i18n.Errorf("无法读取图片尺寸%s：%w", source, err)
+		}
+		ext := strings.ToLower(filepath.Ext(source))
+		name := fmt.Sprintf("%0*d", width, i+1)
+		book.Pages = append(book.Pages, epubPage{
+			ID:        "p" + name,
+			Image:     "images/" + name + ext,
+			XHTML:     "pages/" + name + ".xhtml",
+			MediaType: epubMediaTypes[ext],
+			Width:     config.Width,
+			Height:    config.Height,
+			Source:    source,
+		})
+	}
+	return book, nil
+}
+
+func decodeImageConfig(path string) (image.Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	return config, err
+}
+
+func writeEpub(w io.Writer, book epubBook) error {
+	zw := zip.NewWriter(w)
+
+	//mimetype必须是第一个文件，且不压缩、不带数据描述符
+	mimetype := []byte(epubMimetype)
+	entry, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(mimetype),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := entry.Write(mimetype); err != nil {
+		return err
+	}
+
+	if err := writeZipString(zw, "META-INF/container.xml", containerTemplate); err != nil {
+		return err
+	}
+	if err := writeZipTemplate(zw, "OEBPS/content.opf", packageTemplate, book); err != nil {
+		return err
+	}
+	if err := writeZipTemplate(zw, "OEBPS/nav.xhtml", navTemplate, book); err != nil {
+		return err
+	}
+	for _, page := range book.Pages {
+		if err := writeZipTemplate(zw, "OEBPS/"+page.XHTML, pageTemplate, page); err != nil {
+			return err
+		}
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + page.Image, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if err := copyFileTo(entry, page.Source); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeZipString(zw *zip.Writer, name string, content string) error {
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(entry, content)
+	return err
+}
+
+func writeZipTemplate(zw *zip.Writer, name string, tmpl *template.Template, data any) error {
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(entry, data)
+}
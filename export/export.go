@@ -0,0 +1,72 @@
+package export
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/i18n"
+	"io"
+	"os"
+)
+
+const (
+	FormatCBZ  = "cbz"
+	FormatEPUB = "epub"
+	FormatPDF  = "pdf"
+)
+
+// Formats 支持的导出格式
+var Formats = []string{FormatCBZ, FormatEPUB, FormatPDF}
+
+// Options 控制导出的方式
+type Options struct {
+	Manga       bool //按从右到左的漫画方式阅读
+	VolumePages int  //CBZ每卷的最大页数，超过后拆分为多卷，0表示不拆分
+}
+
+// Export 将画廊目录按format导出，返回生成的文件路径
+func Export(format string, galleryDir string, info eh.GalleryInfo, opts Options) ([]string, error) {
+	switch format {
+	case FormatCBZ:
+		return WriteCBZ(galleryDir, info, opts)
+	case FormatEPUB:
+		path, err := WriteEPUB(galleryDir, info, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	case FormatPDF:
+		path, err := WritePDF(galleryDir, info)
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	}
+	return nil, i18n.Errorf("不支持的导出格式：%s", format)
+}
+
+// writeFileAtomic 先写入临时文件再重命名，避免中断后留下不完整的文件
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
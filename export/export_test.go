@@ -0,0 +1,170 @@
+package export
+
+import (
+	"EhDownloader/eh"
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testGalleryInfo = eh.GalleryInfo{
+	Gid:        "2569708",
+	Token:      "4bd9316841",
+	URL:        "https://e-hentai.org/g/2569708/4bd9316841/",
+	Title:      "[中信出版社] 流浪地球2电影制作手记 The Wandering Earth II FLIM HAND BOOK",
+	TotalImage: 12,
+	TagList: map[string][]string{
+		"language": {"chinese", "translated"},
+		"artist":   {"guo fan"},
+		"parody":   {"the wandering earth"},
+		"other":    {"artbook"},
+	},
+}
+
+// makeGalleryDir 生成一个含有count张扩展名为ext的图片的画廊目录。png为真实的图片，第i张的尺寸为(100+i)x200，
+// 其余扩展名只写入一个字节作为假图片
+func makeGalleryDir(t *testing.T, count int, ext string) string {
+	dir := filepath.Join(t.TempDir(), "gallery")
+	assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	for i := 1; i <= count; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%d.%s", i, ext))
+		if ext != "png" {
+			assert.NoError(t, os.WriteFile(path, []byte{byte(i)}, 0644))
+			continue
+		}
+		file, err := os.Create(path)
+		assert.NoError(t, err)
+		assert.NoError(t, png.Encode(file, image.NewGray(image.Rect(0, 0, 100+i, 200))))
+		assert.NoError(t, file.Close())
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "galleryInfo.json"), []byte("{}"), 0644))
+	return dir
+}
+
+func TestBuildComicInfo(t *testing.T) {
+	comicInfo := BuildComicInfo(testGalleryInfo, 12, true)
+	assert.Equal(t, testGalleryInfo.Title, comicInfo.Title)
+	assert.Equal(t, "guo fan", comicInfo.Writer)
+	assert.Equal(t, "the wandering earth", comicInfo.Series)
+	assert.Equal(t, "zh", comicInfo.LanguageISO)
+	assert.Equal(t, "other:artbook", comicInfo.Tags)
+	assert.Equal(t, testGalleryInfo.URL, comicInfo.Web)
+	assert.Equal(t, 12, comicInfo.PageCount)
+	assert.Equal(t, "YesAndRightToLeft", comicInfo.Manga)
+}
+
+func TestWriteCBZ(t *testing.T) {
+	dir := makeGalleryDir(t, 12, "jpg")
+	paths, err := WriteCBZ(dir, testGalleryInfo, Options{VolumePages: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir + " Vol.01.cbz", dir + " Vol.02.cbz", dir + " Vol.03.cbz"}, paths)
+
+	reader, err := zip.OpenReader(paths[2])
+	assert.NoError(t, err)
+	defer reader.Close()
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	assert.Equal(t, []string{"1.jpg", "2.jpg", comicInfoName}, names)
+
+	file, err := reader.Open(comicInfoName)
+	assert.NoError(t, err)
+	defer file.Close()
+	var comicInfo ComicInfo
+	assert.NoError(t, xml.NewDecoder(file).Decode(&comicInfo))
+	assert.Equal(t, 3, comicInfo.Volume)
+	assert.Equal(t, 2, comicInfo.PageCount)
+}
+
+func readZipFile(t *testing.T, reader *zip.ReadCloser, name string) string {
+	file, err := reader.Open(name)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestWriteEPUB(t *testing.T) {
+	dir := makeGalleryDir(t, 3, "png")
+	path, err := WriteEPUB(dir, testGalleryInfo, Options{Manga: true})
+	assert.NoError(t, err)
+	assert.Equal(t, dir+".epub", path)
+
+	reader, err := zip.OpenReader(path)
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.Equal(t, "mimetype", reader.File[0].Name)
+	assert.Equal(t, zip.Store, reader.File[0].Method)
+	assert.Equal(t, epubMimetype, readZipFile(t, reader, "mimetype"))
+
+	opf := readZipFile(t, reader, "OEBPS/content.opf")
+	assert.Contains(t, opf, "<dc:title>[中信出版社] 流浪地球2电影制作手记 The Wandering Earth II FLIM HAND BOOK</dc:title>")
+	assert.Contains(t, opf, "<dc:language>zh</dc:language>")
+	assert.Contains(t, opf, "<dc:creator>guo fan</dc:creator>")
+	assert.Contains(t, opf, "<dc:subject>parody:the wandering earth</dc:subject>")
+	assert.Contains(t, opf, `properties="cover-image"`)
+	assert.Contains(t, opf, `page-progression-direction="rtl"`)
+	assert.Equal(t, 3, strings.Count(opf, "<itemref "))
+
+	page := readZipFile(t, reader, "OEBPS/pages/0002.xhtml")
+	assert.Contains(t, page, `content="width=102, height=200"`)
+	assert.Contains(t, page, `src="../images/0002.png"`)
+	assert.Contains(t, readZipFile(t, reader, "OEBPS/nav.xhtml"), `<a href="pages/0003.xhtml">3</a>`)
+}
+
+func TestWritePDF(t *testing.T) {
+	dir := makeGalleryDir(t, 2, "png")
+	//第三页为JPEG，应当原样嵌入
+	var jpegData bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&jpegData, image.NewRGBA(image.Rect(0, 0, 300, 400)), nil))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "3.jpg"), jpegData.Bytes(), 0644))
+
+	path, err := WritePDF(dir, testGalleryInfo)
+	assert.NoError(t, err)
+	assert.Equal(t, dir+".pdf", path)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	pdf := string(data)
+	assert.True(t, strings.HasPrefix(pdf, "%PDF-1.4"))
+	assert.Contains(t, pdf, "/Count 3")
+	assert.Contains(t, pdf, "/MediaBox [0 0 101 200]")
+	assert.Contains(t, pdf, "/MediaBox [0 0 300 400]")
+	assert.Contains(t, pdf, "/Title "+pdfString(testGalleryInfo.Title))
+	assert.Contains(t, pdf, "/Author "+pdfString("guo fan"))
+	assert.True(t, bytes.Contains(data, jpegData.Bytes()))
+
+	//startxref应当指向xref表
+	match := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	if assert.NotNil(t, match) {
+		offset, _ := strconv.Atoi(match[1])
+		assert.True(t, strings.HasPrefix(pdf[offset:], "xref\n0 13\n"))
+		//xref中每一项都应当指向对应的对象
+		entries := strings.Split(pdf[offset:], "\n")[3:15]
+		for i, entry := range entries {
+			objectOffset, _ := strconv.Atoi(entry[:10])
+			assert.True(t, strings.HasPrefix(pdf[objectOffset:], fmt.Sprintf("%d 0 obj\n", i+1)))
+		}
+	}
+}
+
+func Test_pdfString(t *testing.T) {
+	assert.Equal(t, "<FEFF00410042>", pdfString("AB"))
+	assert.Equal(t, "<FEFF4E2D6587>", pdfString("中文"))
+}
@@ -0,0 +1,201 @@
+package export
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/i18n"
+	"EhDownloader/utils"
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfImage 一页中嵌入的图片，JPEG直接嵌入原始数据，其余格式解码后以Flate压缩的RGB数据嵌入
+type pdfImage struct {
+	Width      int
+	Height     int
+	ColorSpace string
+	Decode     string //Adobe生成的CMYK JPEG是反相存储的，需要用Decode数组还原
+	Filter     string
+	Data       []byte
+}
+
+// pdfWriter 记录每个对象的偏移量，用于最后生成xref表
+type pdfWriter struct {
+	w       *bufio.Writer
+	offset  int
+	objects []int
+	err     error
+}
+
+// WritePDF 将画廊目录中的图片按页码顺序生成PDF，每页大小与图片一致，保存在画廊目录旁边，返回生成的文件路径
+func WritePDF(galleryDir string, info eh.GalleryInfo) (string, error) {
+	pages, err := utils.ListImageFiles(galleryDir)
+	if err != nil {
+		return "", err
+	}
+	if len(pages) == 0 {
+		return "", i18n.Errorf("目录中没有图片：%s", galleryDir)
+	}
+
+	pdfPath := filepath.Clean(galleryDir) + ".pdf"
+	err = writeFileAtomic(pdfPath, func(w io.Writer) error {
+		return writePDF(w, pages, info)
+	})
+	return pdfPath, err
+}
+
+// loadPDFImage 读取图片，JPEG保持原始数据不重新编码
+func loadPDFImage(path string) (pdfImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pdfImage{}, err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return pdfImage{}, err
+	}
+
+	if format == "jpeg" {
+		img := pdfImage{Width: config.Width, Height: config.Height, ColorSpace: "/DeviceRGB", Filter: "/DCTDecode", Data: data}
+		switch config.ColorModel {
+		case color.GrayModel:
+			img.ColorSpace = "/DeviceGray"
+		case color.CMYKModel:
+			img.ColorSpace = "/DeviceCMYK"
+			img.Decode = " /Decode [1 0 1 0 1 0 1 0]"
+		}
+		return img, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return pdfImage{}, err
+	}
+	return flatePDFImage(img), nil
+}
+
+// flatePDFImage 将任意图片转换为RGB数据并压缩，透明部分与白色背景混合
+func flatePDFImage(img image.Image) pdfImage {
+	bounds := img.Bounds()
+	var buffer bytes.Buffer
+	zw := zlib.NewWriter(&buffer)
+	row := make([]byte, 0, bounds.Dx()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			//RGBA返回预乘alpha的16位分量，叠加白色背景
+			white := 0xffff - a
+			row = append(row, byte((r+white)>>8), byte((g+white)>>8), byte((b+white)>>8))
+		}
+		_, _ = zw.Write(row)
+	}
+	_ = zw.Close()
+	return pdfImage{Width: bounds.Dx(), Height: bounds.Dy(), ColorSpace: "/DeviceRGB", Filter: "/FlateDecode", Data: buffer.Bytes()}
+}
+
+func writePDF(w io.Writer, pages []string, info eh.GalleryInfo) error {
+	pw := &pdfWriter{w: bufio.NewWriter(w)}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	//对象1为Catalog，2为Pages，3为Info，之后每页依次为Page、Contents、Image三个对象
+	const firstPageObject = 4
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPageObject+i*3))
+	}
+
+	pw.beginObject()
+	pw.printf("<< /Type /Catalog /Pages 2 0 R >>")
+	pw.endObject()
+
+	pw.beginObject()
+	pw.printf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+	pw.endObject()
+
+	pw.beginObject()
+	pw.printf("<< /Title %s /Author %s /Keywords %s /Producer %s >>",
+		pdfString(info.Title),
+		pdfString(strings.Join(append(append([]string{}, info.TagList["artist"]...), info.TagList["group"]...), ", ")),
+		pdfString(strings.Join(info.NamespacedTags(nil), ", ")),
+		pdfString("EhDownloader"))
+	pw.endObject()
+
+	for i, page := range pages {
+		img, err := loadPDFImage(page)
+		if err != nil {
+			return i18n.Errorf("无法读取图片%s：%w", page, err)
+		}
+		pageObject := firstPageObject + i*3
+
+		pw.beginObject()
+		pw.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Contents %d 0 R /Resources << /XObject << /Im0 %d 0 R >> >> >>",
+			img.Width, img.Height, pageObject+1, pageObject+2)
+		pw.endObject()
+
+		content := fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", img.Width, img.Height)
+		pw.beginObject()
+		pw.printf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content)
+		pw.endObject()
+
+		pw.beginObject()
+		pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s%s /BitsPerComponent 8 /Filter %s /Length %d >>\nstream\n",
+			img.Width, img.Height, img.ColorSpace, img.Decode, img.Filter, len(img.Data))
+		pw.write(img.Data)
+		pw.printf("\nendstream")
+		pw.endObject()
+	}
+
+	xrefOffset := pw.offset
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.objects)+1)
+	for _, offset := range pw.objects {
+		pw.printf("%010d 00000 n \n", offset)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.objects)+1, xrefOffset)
+
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+func (pw *pdfWriter) write(data []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(data)
+	pw.offset += n
+	pw.err = err
+}
+
+func (pw *pdfWriter) printf(format string, args ...any) {
+	pw.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+func (pw *pdfWriter) beginObject() {
+	pw.objects = append(pw.objects, pw.offset)
+	pw.printf("%d 0 obj\n", len(pw.objects))
+}
+
+func (pw *pdfWriter) endObject() {
+	pw.printf("\nendobj\n")
+}
+
+// pdfString 将文本编码为带BOM的UTF-16BE十六进制字符串，以支持中日文标题
+func pdfString(s string) string {
+	var builder strings.Builder
+	builder.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&builder, "%04X", unit)
+	}
+	builder.WriteString(">")
+	return builder.String()
+}
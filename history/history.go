@@ -0,0 +1,85 @@
+package history
+
+import (
+	"EhDownloader/utils"
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"time"
+)
+
+const dbFileName = "history.db"
+
+var galleryBucket = []byte("galleries")
+
+// Entry 一条已完成画廊的下载记录，以gid为键
+type Entry struct {
+	Gid        string    `json:"gid"`
+	Token      string    `json:"token"`
+	Title      string    `json:"title"`
+	Path       string    `json:"path"`
+	ImageCount int       `json:"image_count"`
+	FinishedAt time.Time `json:"finished_at"`
+	Version    string    `json:"version"`
+}
+
+// Store 基于bbolt的全局下载历史，跨多次运行保留
+type Store struct {
+	db *bbolt.DB
+}
+
+// DefaultPath 返回用户数据目录下的历史数据库路径
+func DefaultPath() (string, error) {
+	dir, err := utils.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, dbFileName), nil
+}
+
+// Open 打开(不存在时创建)历史数据库，数据库被其他进程占用时等待一秒后报错
+func Open(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(galleryBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get 查询gid对应的下载记录，第二个返回值表示记录是否存在
+func (s *Store) Get(gid string) (Entry, bool, error) {
+	var entry Entry
+	var found bool
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(galleryBucket).Get([]byte(gid))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &entry)
+	})
+	return entry, found, err
+}
+
+// Put 写入或覆盖一条下载记录
+func (s *Store) Put(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(galleryBucket).Put([]byte(entry.Gid), data)
+	})
+}
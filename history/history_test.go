@@ -0,0 +1,39 @@
+package history
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFileName)
+	store, err := Open(path)
+	assert.NoError(t, err)
+
+	_, found, err := store.Get("2569708")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	entry := Entry{
+		Gid:        "2569708",
+		Token:      "4bd9316841",
+		Title:      "[中信出版社] 流浪地球2电影制作手记 The Wandering Earth II FLIM HAND BOOK",
+		Path:       "images/[中信出版社] 流浪地球2电影制作手记 The Wandering Earth II FLIM HAND BOOK",
+		ImageCount: 468,
+		FinishedAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		Version:    "0.9.1",
+	}
+	assert.NoError(t, store.Put(entry))
+	assert.NoError(t, store.Close())
+
+	//重新打开后记录仍然存在
+	store, err = Open(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	got, found, err := store.Get("2569708")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, entry, got)
+}
@@ -0,0 +1,122 @@
+package hook
+
+import (
+	"EhDownloader/i18n"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Event 触发钩子的时机
+type Event string
+
+const (
+	ImageSaved    Event = "image"  //一张图片保存后
+	GalleryDone   Event = "done"   //画廊下载完成后
+	GalleryFailed Event = "failed" //画廊下载失败后
+
+	DefaultTimeout = time.Minute
+)
+
+// Payload 传给钩子命令的上下文，同时以EH_开头的环境变量和stdin上的JSON提供
+type Payload struct {
+	Event      Event  `json:"event"`
+	Gid        string `json:"gid"`
+	URL        string `json:"url"`
+	GalleryDir string `json:"gallery_dir"`
+	InfoJson   string `json:"info_json"` //画廊信息文件的路径
+	Image      string `json:"image,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// env 返回Payload对应的环境变量
+func (p Payload) env() []string {
+	return []string{
+		"EH_EVENT=" + string(p.Event),
+		"EH_GID=" + p.Gid,
+		"EH_URL=" + p.URL,
+		"EH_GALLERY_DIR=" + p.GalleryDir,
+		"EH_INFO_JSON=" + p.InfoJson,
+		"EH_IMAGE=" + p.Image,
+		"EH_STATUS=" + p.Status,
+		"EH_ERROR=" + p.Error,
+	}
+}
+
+// Runner 按事件执行用户配置的命令，命令交给系统shell解释
+type Runner struct {
+	Commands map[Event]string
+	Timeout  time.Duration //单次执行的超时时间，0表示使用DefaultTimeout
+}
+
+// Enabled 是否为event配置了命令
+func (r *Runner) Enabled(event Event) bool {
+	return r != nil && r.Commands[event] != ""
+}
+
+// Run 执行payload.Event对应的命令，输出与退出状态写入日志。未配置命令时直接返回
+func (r *Runner) Run(payload Payload) error {
+	if !r.Enabled(payload.Event) {
+		return nil
+	}
+	command := r.Commands[payload.Event]
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), payload.env()...)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	//超时后子进程可能还占用着输出管道，最多再等一秒
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	for _, line := range strings.Split(strings.TrimRight(output.String(), "\n"), "\n") {
+		if line != "" {
+			log.Printf(i18n.T("钩子%s：%s"), payload.Event, line)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = i18n.Errorf("钩子%s执行超过%v被终止", payload.Event, timeout)
+		log.Println(err)
+		return err
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		log.Printf(i18n.T("钩子%s退出状态为%d，耗时%v"), payload.Event, exitErr.ExitCode(), time.Since(start).Round(time.Millisecond))
+		return i18n.Errorf("钩子%s退出状态为%d", payload.Event, exitErr.ExitCode())
+	}
+	if err != nil {
+		log.Printf(i18n.T("执行钩子%s出错：%v"), payload.Event, err)
+		return err
+	}
+	log.Printf(i18n.T("钩子%s退出状态为0，耗时%v"), payload.Event, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// shellCommand 用系统shell执行command，以支持管道和重定向
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,55 @@
+package hook
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunner_Run(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("测试命令依赖sh")
+	}
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env.txt")
+	stdinFile := filepath.Join(dir, "stdin.json")
+	runner := &Runner{
+		Commands: map[Event]string{
+			GalleryDone:   `echo "$EH_EVENT $EH_GID $EH_STATUS $EH_GALLERY_DIR" > ` + envFile + `; cat > ` + stdinFile,
+			GalleryFailed: "exit 3",
+			ImageSaved:    "sleep 5",
+		},
+		Timeout: 200 * time.Millisecond,
+	}
+	payload := Payload{Event: GalleryDone, Gid: "2569708", GalleryDir: "/tmp/gallery", InfoJson: "/tmp/gallery/galleryInfo.json", Status: "ok"}
+
+	assert.NoError(t, runner.Run(payload))
+	env, err := os.ReadFile(envFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "done 2569708 ok /tmp/gallery", strings.TrimSpace(string(env)))
+	stdin, err := os.ReadFile(stdinFile)
+	assert.NoError(t, err)
+	var got Payload
+	assert.NoError(t, json.Unmarshal(stdin, &got))
+	assert.Equal(t, payload, got)
+
+	payload.Event = GalleryFailed
+	err = runner.Run(payload)
+	assert.ErrorContains(t, err, "3")
+
+	payload.Event = ImageSaved
+	start := time.Now()
+	assert.Error(t, runner.Run(payload))
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	//未配置命令的事件直接跳过
+	assert.False(t, (&Runner{}).Enabled(GalleryDone))
+	assert.NoError(t, (&Runner{}).Run(payload))
+	var nilRunner *Runner
+	assert.NoError(t, nilRunner.Run(payload))
+}
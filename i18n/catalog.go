@@ -0,0 +1,217 @@
+package i18n
+
+// english 中文消息到英文的翻译，键为代码中的中文原文
+var english = map[string]string{
+	//命令行参数与输出
+	"已有下载记录":            "already downloaded",
+	"未知的url格式：%s":       "unknown url format: %s",
+	"%w：%s 已于%s下载到%s":   "%w: %s was downloaded at %s to %s",
+	"写入%s元数据失败：%w":      "writing %s metadata failed: %w",
+	"转换图片格式失败：%w":       "converting images failed: %w",
+	"转换格式的图片数量:":        "Images converted:",
+	"导出%s失败：%w":         "exporting %s failed: %w",
+	"已导出:":              "Exported:",
+	"%d时%d分%d秒":         "%dh%dm%ds",
+	"%d分%d秒":            "%dm%ds",
+	"%d秒":               "%ds",
+	"E-Hentai画廊下载器":     "E-Hentai gallery downloader",
+	"下载画廊":              "download galleries",
+	"画廊网址":              "gallery URL",
+	"只获取画廊信息，默认以JSON输出": "only fetch gallery info, printed as JSON by default",
+	"包含画廊网址的文件":         "file of gallery URLs",
+	"将画廊信息写入输出目录中的画廊目录，而不是输出":                                     "write the info into gallery directories under the output directory instead of printing it",
+	"--save时额外写入的元数据文件，可多次指定：%s":                                  "extra metadata file written with --save, may be repeated: %s",
+	"检查已下载的画廊目录中的图片是否齐全且完整":                                       "check that downloaded gallery directories are complete and undamaged",
+	"EhDownloader verify [options] [<dir>...]，不指定目录时检查输出目录中的全部画廊": "EhDownloader verify [options] [<dir>...], checks every gallery in the output directory when no directory is given",
+	"输出目录": "output directory",
+	"重新下载列表文件中失败的画廊":                                                                "download the failed galleries of a list file again",
+	"包含画廊网址的文件，必须指定":                                                                "file of gallery URLs, required",
+	"不下载，只把失败的网址导出为新的列表文件":                                                          "do not download, only export the failed URLs to a new list file",
+	"将已下载的画廊目录转换图片格式或导出为CBZ、EPUB、PDF":                                               "convert downloaded gallery directories or export them as CBZ, EPUB or PDF",
+	"EhDownloader export --format <format> [options] [<dir>...]，不指定目录时导出输出目录中的全部画廊": "EhDownloader export --format <format> [options] [<dir>...], exports every gallery in the output directory when no directory is given",
+	"配置文件路径，默认为用户配置目录下的EhDownloader/%s":                                             "path of the config file, defaults to EhDownloader/%s in the user config directory",
+	"使用配置文件中的指定配置，不指定时使用其中的default":                                                 "use the named profile from the config file, defaults to its default profile",
+	"请求时附带的Cookie，如ipb_member_id=...; ipb_pass_hash=...":                            "cookie sent with each request, e.g. ipb_member_id=...; ipb_pass_hash=...",
+	"请求时使用的User-Agent，默认为内置的Chrome UA":                                              "User-Agent sent with each request, defaults to a built-in Chrome UA",
+	"额外的请求头，形如\"Referer: https://e-hentai.org/\"，可多次指定":                             "extra request header such as \"Referer: https://e-hentai.org/\", may be repeated",
+	"代理地址，支持http、https与socks5，如socks5://127.0.0.1:1080":                             "proxy URL, http, https and socks5 are supported, e.g. socks5://127.0.0.1:1080",
+	"同时下载的图片数": "number of images downloaded at the same time",
+	"每张图片开始下载之间的等待时间，默认随机等待2-3秒":                "delay between starting image downloads, defaults to a random 2-3 seconds",
+	"目录页每页的缩略图数，需与网站上的设置一致":                     "thumbnails per index page, must match your site settings",
+	"配置%s中有未知的设置：%s":                            "profile %s has an unknown setting: %s",
+	"配置中的设置%s无效：%w":                             "invalid profile setting %s: %w",
+	"请求头格式错误，应为\"名称: 值\"：%s":                    "malformed header, expected \"Name: value\": %s",
+	"新建的画廊目录以\"标题 [gid]\"命名，等同于--layout \"%s\"": "name new gallery directories \"title [gid]\", same as --layout \"%s\"",
+	"画廊目录模板，以/分隔多级目录，可用字段：{title}、{title_jpn}、{category}、{artist}、{group}、{parody}、{language}、{gid}、{year}，{a|b}取第一个不为空的字段": "gallery directory template, / separates levels, fields: {title}, {title_jpn}, {category}, {artist}, {group}, {parody}, {language}, {gid}, {year}; {a|b} takes the first non-empty field",
+	"按标签把画廊放到其他根目录，形如language:chinese=/mnt/chinese，可多次指定":                                                                   "put galleries with a tag under another root, e.g. language:chinese=/mnt/chinese, may be repeated",
+	"目录名和文件名的最大字节数，超出时截断标题或原始文件名，0为不限制":                                                                                     "maximum bytes in directory and file names, longer titles or original names are truncated, 0 for no limit",
+	"目录名和文件名统一为NFC并把全角字符转为半角":                                                                                               "normalize directory and file names to NFC and convert full-width characters to half-width",
+	"图片文件名模板，可用字段：{index}、{index:3}、{index:auto}、{name}、{hash}、{gid}、{ext}":                                                 "image file name template, fields: {index}, {index:3}, {index:auto}, {name}, {hash}, {gid}, {ext}",
+	"按上传时的原始文件名保存图片，以序号作前缀，等同于--filename \"%s\"":                                                                            "save images under their original upload names prefixed with the index, same as --filename \"%s\"",
+	"将图片转换为指定格式，动态GIF保持不变：%s":                                                                                               "convert images to this format, animated GIFs are kept: %s",
+	"转换为JPEG时的质量，1-100":                       "JPEG quality when converting, 1-100",
+	"导出的格式，可多次指定：%s":                          "export format, may be repeated: %s",
+	"导出时标记为从右到左阅读的漫画":                         "mark exports as right-to-left manga",
+	"CBZ每卷的最大页数，超过后拆分为多卷，0为不拆分":               "maximum pages per CBZ volume before splitting, 0 for no splitting",
+	"忽略下载历史，强制重新下载":                           "ignore the download history and download again",
+	"画廊有更新版本时下载最新版本，并复用旧版本中相同的图片":             "download the newest version of updated galleries, reusing identical images from the old version",
+	"只下载封面和缩略图并生成总览图，用于下载前预览":                 "only download the cover and thumbnails and build a contact sheet, to preview before downloading",
+	"缺失图片的重试轮数":                               "retry rounds for missing images",
+	"额外写入的元数据文件，可多次指定：%s":                     "extra metadata file to write, may be repeated: %s",
+	"每张图片保存后执行的命令，上下文见EH_开头的环境变量和stdin上的JSON": "command run after each image is saved, context is in EH_* environment variables and JSON on stdin",
+	"画廊下载完成后执行的命令":                            "command run after a gallery finishes downloading",
+	"画廊下载失败后执行的命令":                            "command run after a gallery fails to download",
+	"钩子命令的超时时间":                               "timeout for hook commands",
+	"在stdout上逐行输出JSON格式的下载事件，其余输出改到stderr":    "write download events to stdout as one JSON object per line, other output goes to stderr",
+	"不显示进度条，逐张输出图片保存日志":                       "do not show progress bars, log each saved image instead",
+	"不支持的导出格式：%s":                             "unsupported export format: %s",
+	"无法打开下载历史%s：%w":                           "cannot open download history %s: %w",
+	"请指定画廊网址或列表文件":                            "specify gallery URLs or a list file",
+	"列表文件不能与画廊网址同时使用":                         "a list file cannot be combined with gallery URLs",
+	"队列中已完成的gallery数量:":                       "Galleries already finished in the queue:",
+	"开始下载gallery:":                            "Downloading gallery:",
+	"跳过:":                                     "Skipped:",
+	"下载失败:":                                   "Download failed:",
+	"gallery下载完毕:":                            "Gallery downloaded:",
+	"所有gallery下载完毕，共耗时:":                      "All galleries done, elapsed:",
+	"已中止，剩余%d个gallery未下载：%w":                  "aborted with %d galleries not downloaded: %w",
+	"有%d个下载失败":                                "%d downloads failed",
+	"获取失败:":                                   "Fetch failed:",
+	"有%d个画廊信息获取失败":                            "failed to fetch info for %d galleries",
+	"%s中没有画廊目录":                               "no gallery directories in %s",
+	"完整:":                                     "Complete:",
+	"不完整:":                                    "Incomplete:",
+	"  缺少画廊信息文件":                              "  missing gallery info file",
+	"  缺失图片:":                                 "  missing images:",
+	"  损坏的图片:":                                "  corrupt images:",
+	"%d个画廊中有%d个不完整":                           "%[2]d of %[1]d galleries are incomplete",
+	"请用--list指定列表文件":                          "specify the list file with --list",
+	"%s\t尝试%d次\t%s\n":                         "%s\t%d attempts\t%s\n",
+	"没有下载失败的gallery":                          "No failed galleries",
+	"共%d个失败的url，已导出到%s\n":                     "Exported %d failed URLs to %s\n",
+	"请用--format指定导出格式或用--convert指定转换格式":       "specify an export format with --format or a conversion format with --convert",
+	"%s转换图片格式失败：%w":                           "%s: converting images failed: %w",
+	"%s导出%s失败：%w":                             "%s: exporting %s failed: %w",
+	"界面语言：%s，默认按LC_ALL、LC_MESSAGES、LANG判断":    "interface language: %s, detected from LC_ALL, LC_MESSAGES and LANG by default",
+	"只获取画廊信息并输出每个画廊的下载计划，不下载图片，可与--json同用": "only fetch gallery info and print a download plan for each gallery without downloading images, works with --json",
+	"列表文件%s第%d行：%w": "list file %s line %d: %w",
+	"只下载选中的页，如1-20,35,40-，列表文件中可用pages=为单个画廊指定":                                                            "only download the selected pages, like 1-20,35,40-; set pages= in list files to choose them per gallery",
+	"包含画廊网址的文件，-为标准输入。每行一个网址，其后可用key=value指定output、pages、profile、priority，也可以是.json或.csv文件。进度保存在同目录的队列文件中": "file with gallery URLs, - for stdin. One URL per line, optionally followed by key=value for output, pages, profile or priority; .json and .csv lists are also accepted. Progress is kept in a queue file next to it",
+	"读取列表文件%s失败：%w": "reading list file %s failed: %w",
+	"从聊天记录、HTML书签、收藏页或Markdown等任意文件中找出画廊链接，去重后加入列表文件": "find gallery links in any file, such as chat logs, HTML bookmarks, saved favorites pages or Markdown notes, and add them to a list file without duplicates",
+	"追加到的列表文件，已有的画廊不再加入，不指定时把网址输出到stdout":             "list file to append to, galleries already in it are skipped; without it the URLs are written to stdout",
+	"只显示汇总和待加入的网址，不写入列表文件":                            "only show the summary and the URLs that would be added, without writing the list file",
+	"请指定要导入的文件":         "specify the files to import",
+	"%s：%d个画廊链接":        "%s: %d gallery links",
+	"只能导入到文本格式的列表文件：%s": "can only import into a text list file: %s",
+	"共%d个链接，按gid去重后为%d个画廊，其中%d个已在列表中，%d个待加入": "%d links, %d galleries after removing duplicates by gid, %d already in the list, %d to add",
+	"%s从%s导入": "imported on %s from %s",
+	"已加入%s，可用download -l %s下载":                      "Added to %s, download them with download -l %s",
+	"状态\t标题\t已有\t复用\t需下载\t预计大小\t目录或原因":              "STATUS\tTITLE\tPRESENT\tREUSED\tFETCH\tEST. SIZE\tDIR OR REASON",
+	"图片已全部下载":                                       "all images already present",
+	"已中止，剩余%d个gallery未获取信息：%w":                      "aborted with info for %d galleries not fetched: %w",
+	"共%d个gallery，%d个需要下载，%d个跳过，%d个出错；需下载%d张图片，预计%s": "%d galleries: %d to download, %d skipped, %d errors; %d images to fetch, about %s",
+	"其中%d个gallery的页面没有显示大小，未计入预计大小":                 "%d of them do not show a file size and are not included in the estimate",
+	"下载":     "download",
+	"跳过":     "skip",
+	"出错":     "error",
+	"%s（新建）": "%s (new)",
+	"，从%s升级": ", upgrade from %s",
+
+	//画廊下载
+	"IP已被E-Hentai暂时封禁":                "IP temporarily banned by E-Hentai",
+	"图片配额已用完":                         "image quota exceeded",
+	"无法从url中解析gid：%s":                 "cannot parse gid from url: %s",
+	"选择的页%s不在画廊的%d页之内":                "selected pages %s are outside the gallery's %d pages",
+	"选择的页：%s，共%d页":                    "Selected pages: %s, %d in total",
+	"解析url出错:":                        "Error parsing URL:",
+	"图片页中未找到图片：%s":                    "no image found on image page: %s",
+	"保存图片%s出错：%v":                     "Error saving image: %s by error %v",
+	"图片已保存:":                          "Image saved:",
+	"获取第%d页目录出错：%v":                   "Error fetching index page %d: %v",
+	"第%d/%d轮重试，%d张图片，等待%v":            "Retry round %d/%d for %d images after %v",
+	"解析第%d张图片出错：%v":                   "Error resolving image %d: %v",
+	"该画廊有更新的版本:":                      "Newer versions of this gallery are available:",
+	"升级到最新版本:":                        "Upgrading to the newest version:",
+	"图片总数:":                           "Total Image:",
+	"发现下载记录":                          "Found previous download",
+	"画廊信息获取完毕，程序自动退出。":                "Gallery info saved, exiting.",
+	"预览已保存:":                          "Preview saved:",
+	"从%s复用图片出错：%v":                    "Error reusing images from %s: %v",
+	"从旧版本复用的图片数量:":                    "Images reused from the old version:",
+	"本gallery已经下载完毕":                  "This gallery is already complete",
+	"剩余图片数量:":                         "Images remaining:",
+	"\n当前目录页:":                        "\nCurrent index:",
+	"当前目录页url：%s":                     "Current index url: %s",
+	"解析图片%s出错：%v":                     "Error resolving image %s: %v",
+	"等待%s秒...":                        "Sleep %s seconds...",
+	"下载中止，%d张图片缺失：%w":                 "download aborted with %d images missing: %w",
+	"缺失图片:":                           "Missing images:",
+	"重试中止，%d张图片缺失：%w":                 "retry aborted with %d images missing: %w",
+	"重试%d轮后仍有%d张图片缺失：%v":              "%[2]d images still missing after %[1]d retry rounds: %[3]v",
+	"图片下载完毕":                          "Images downloaded",
+	"目录模板不能为空":                        "directory template cannot be empty",
+	"目录模板中有未知的字段%s：%s":                "unknown field %s in directory template: %s",
+	"无效的路由规则，应为namespace:value=目录：%s": "invalid route rule, expected namespace:value=dir: %s",
+	"代理地址无效：%s":                       "invalid proxy URL: %s",
+	"保存封面出错：%v":                       "Error saving cover: %v",
+	"目录页中没有找到缩略图":                     "no thumbnails found on the index pages",
+	"无法解码缩略图%s：%w":                    "cannot decode thumbnail sprite %s: %w",
+	"预览已保存：%d张缩略图，来自%d张雪碧图":           "Preview saved: %d thumbnails from %d sprites",
+
+	//进度显示
+	"，%s/s，剩余%s":   ", %s/s, ETA %s",
+	"全部画廊":         "All galleries",
+	"  %s/s  剩余%s": "  %s/s  ETA %s",
+
+	//配置文件
+	"配置文件%s格式错误：%w":           "malformed config file %s: %w",
+	"配置文件中没有名为%s的配置，可用的配置：%s": "no profile named %s in the config file, available profiles: %s",
+	"设置%s的列表中不能嵌套列表或映射":       "the list for setting %s cannot contain lists or maps",
+
+	//格式转换
+	"不支持转换为%s，可选：%s":      "cannot convert to %s, available: %s",
+	"JPEG质量必须在1到100之间：%d": "JPEG quality must be between 1 and 100: %d",
+	"转换图片%s出错：%v":         "Error converting image: %s by error %v",
+
+	//导出
+	"目录中没有图片：%s":    "no images in directory: %s",
+	"无法读取图片尺寸%s：%w": "cannot read image size of %s: %w",
+	"无法读取图片%s：%w":   "cannot read image %s: %w",
+
+	//钩子
+	"钩子%s：%s":          "Hook %s: %s",
+	"钩子%s执行超过%v被终止":    "hook %s killed after running longer than %v",
+	"钩子%s退出状态为%d，耗时%v": "Hook %s exited with status %d after %v",
+	"钩子%s退出状态为%d":      "hook %s exited with status %d",
+	"执行钩子%s出错：%v":      "Error running hook %s: %v",
+	"钩子%s退出状态为0，耗时%v":  "Hook %s exited with status 0 after %v",
+
+	//元数据
+	"未知的元数据格式：%s，可选：%s": "unknown metadata format: %s, available: %s",
+
+	//列表文件
+	"第%d行：%w":               "line %d: %w",
+	"第%d行：缺少画廊网址":           "line %d: missing gallery URL",
+	"第%d行：选项应为key=value：%s": "line %d: options must be key=value: %s",
+	"引号没有闭合":                "unterminated quote",
+	"未知的选项%s，可用的选项：%s":      "unknown option %s, available options: %s",
+	"优先级应为整数：%s":            "priority must be an integer: %s",
+	"JSON列表格式错误：%w":         "malformed JSON list: %w",
+	"第%d项应为网址或对象：%w":        "item %d must be a URL or an object: %w",
+	"第%d项中%s的值无效：%w":        "invalid value for %[2]s in item %[1]d: %[3]w",
+	"CSV列表格式错误：%w":          "malformed CSV list: %w",
+	"CSV列表的第一行必须包含url列":     "the first row of a CSV list must contain a url column",
+
+	//文件名与工具函数
+	"文件名模板中{index:%s}的宽度无效":      "invalid width in {index:%s} of the file name template",
+	"文件名模板中有未知的字段{%s}":           "unknown field {%s} in the file name template",
+	"文件名模板中必须包含且只包含一个{index}：%s": "the file name template must contain exactly one {index}: %s",
+	"名称中不可截断的部分超过了%d字节：%s":       "the part of the name that cannot be truncated exceeds %d bytes: %s",
+	"无效的页码：%s":                   "invalid page selection: %s",
+	"创建文件出错:":                    "File creation error:",
+	"JSON编码出错:":                  "JSON encoding error:",
+	"打开文件出错:":                    "File open error:",
+	"无法确定用户数据目录":                 "cannot determine the user data directory",
+	"遍历目录出错:":                    "Error walking directory:",
+}
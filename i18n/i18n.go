@@ -0,0 +1,95 @@
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Lang 界面语言
+type Lang string
+
+const (
+	Chinese Lang = "zh" //简体中文，消息的原文
+	English Lang = "en"
+)
+
+// Langs 可选的语言
+var Langs = []string{string(Chinese), string(English)}
+
+var current atomic.Value
+
+func init() {
+	current.Store(Detect())
+}
+
+// Detect 按LC_ALL、LC_MESSAGES、LANG的顺序从环境变量判断语言，zh开头为中文，C、POSIX与其他语言为英文，都未设置时为中文
+func Detect() Lang {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			if lang, err := Parse(value); err == nil {
+				return lang
+			}
+			return English
+		}
+	}
+	return Chinese
+}
+
+// Parse 解析语言名，接受zh、en以及zh_CN.UTF-8、en-US这样的区域设置
+func Parse(name string) (Lang, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case strings.HasPrefix(name, string(Chinese)):
+		return Chinese, nil
+	case strings.HasPrefix(name, string(English)), name == "c", strings.HasPrefix(name, "c."), name == "posix":
+		return English, nil
+	}
+	return "", fmt.Errorf("unsupported language: %s, available: %s", name, strings.Join(Langs, ", "))
+}
+
+// Set 设置之后输出的消息所用的语言
+func Set(lang Lang) {
+	current.Store(lang)
+}
+
+// Current 当前的语言
+func Current() Lang {
+	return current.Load().(Lang)
+}
+
+// T 把中文消息翻译为当前语言，目录中没有的消息原样返回
+func T(msg string) string {
+	if Current() == Chinese {
+		return msg
+	}
+	if translated, ok := english[msg]; ok {
+		return translated
+	}
+	return msg
+}
+
+// Sprintf 按翻译后的格式格式化
+func Sprintf(format string, args ...any) string {
+	return fmt.Sprintf(T(format), args...)
+}
+
+// Errorf 按翻译后的格式生成错误，支持%w
+func Errorf(format string, args ...any) error {
+	return fmt.Errorf(T(format), args...)
+}
+
+// message 在输出时才翻译的错误，用于包初始化时创建、之后才确定语言的哨兵错误
+type message struct {
+	msg string
+}
+
+func (m *message) Error() string {
+	return T(m.msg)
+}
+
+// Error 创建在输出时按当前语言翻译的错误，每次调用返回不同的错误，可用errors.Is比较
+func Error(msg string) error {
+	return &message{msg: msg}
+}
@@ -0,0 +1,106 @@
+package i18n
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	verbRegex = regexp.MustCompile(`%(?:\[(\d+)\])?[-+# 0]*\d*(?:\.\d+)?([a-zA-Z%])`)
+	callRegex = regexp.MustCompile(`i18n\.(?:T|Sprintf|Errorf|Error)\(("(?:[^"\\\n]|\\.)*")`)
+)
+
+// verbs 返回格式中按参数位置排列的动词，如"%[2]d %[1]s"为["1:s", "2:d"]
+func verbs(format string) []string {
+	var result []string
+	next := 1
+	for _, match := range verbRegex.FindAllStringSubmatch(format, -1) {
+		if match[2] == "%" {
+			continue
+		}
+		if match[1] != "" {
+			next, _ = strconv.Atoi(match[1])
+		}
+		result = append(result, strconv.Itoa(next)+":"+match[2])
+		next++
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestCatalogVerbs(t *testing.T) {
+	assert.Equal(t, []string{"1:d", "2:d"}, verbs("%[2]d of %[1]d"))
+	for msg, translated := range english {
+		assert.Equal(t, verbs(msg), verbs(translated), msg)
+	}
+}
+
+// TestCatalogComplete 代码中每条经过i18n的消息都要有英文翻译
+func TestCatalogComplete(t *testing.T) {
+	err := filepath.WalkDir("..", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range callRegex.FindAllStringSubmatch(string(data), -1) {
+			msg, err := strconv.Unquote(match[1])
+			assert.NoError(t, err)
+			_, ok := english[msg]
+			assert.True(t, ok, "%s: %q没有英文翻译", path, msg)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestParse(t *testing.T) {
+	for name, want := range map[string]Lang{"zh": Chinese, "zh_CN.UTF-8": Chinese, "zh-TW": Chinese, "en": English, "en_US.UTF-8": English, "C": English, "C.UTF-8": English, "POSIX": English} {
+		lang, err := Parse(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, lang, name)
+	}
+	_, err := Parse("fr_FR")
+	assert.Error(t, err)
+}
+
+func TestDetect(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_MESSAGES", "")
+	t.Setenv("LANG", "")
+	assert.Equal(t, Chinese, Detect())
+	t.Setenv("LANG", "en_US.UTF-8")
+	assert.Equal(t, English, Detect())
+	t.Setenv("LC_ALL", "zh_CN.UTF-8")
+	assert.Equal(t, Chinese, Detect())
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	assert.Equal(t, English, Detect())
+}
+
+func TestTranslate(t *testing.T) {
+	defer Set(Current())
+	sentinel := Error("已有下载记录")
+	wrapped := Errorf("%w：%s 已于%s下载到%s", sentinel, "流浪地", "2024-01-01 00:00:00", "/tmp")
+
+	Set(Chinese)
+	assert.Equal(t, "有3个下载失败", Sprintf("有%d个下载失败", 3))
+	assert.Equal(t, "已有下载记录", sentinel.Error())
+
+	Set(English)
+	assert.Equal(t, "3 downloads failed", Sprintf("有%d个下载失败", 3))
+	assert.Equal(t, "2 of 5 galleries are incomplete", Sprintf("%d个画廊中有%d个不完整", 5, 2))
+	assert.Equal(t, "already downloaded", sentinel.Error())
+	assert.Equal(t, "没有翻译的消息", T("没有翻译的消息"))
+	assert.True(t, errors.Is(wrapped, sentinel))
+	assert.False(t, errors.Is(wrapped, Error("已有下载记录")))
+}
@@ -0,0 +1,283 @@
+package listfile
+
+import (
+	"EhDownloader/i18n"
+	"EhDownloader/utils"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/spf13/cast"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Stdin 作为列表文件路径时表示从标准输入读取
+const Stdin = "-"
+
+// Format 列表文件的格式
+type Format string
+
+const (
+	Text Format = "text" //每行一个网址，后面可跟key=value形式的选项，#开始注释
+	JSON Format = "json" //网址字符串或带url等字段的对象组成的数组
+	CSV  Format = "csv"  //第一行为列名，必须有url列
+)
+
+// Keys 可以为单个画廊指定的选项
+var Keys = []string{"output", "pages", "profile", "priority"}
+
+// Entry 列表中的一个画廊，及为它单独指定的选项
+type Entry struct {
+	URL      string
+	Line     int                 //在文件中的行号，JSON中为数组中的序号，从1开始
+	Output   string              //输出目录，为空时使用参数中的目录
+	Pages    utils.PageSelection //要下载的页，为空时使用参数中的--pages
+	Profile  string              //使用的配置，为空时使用参数中的--profile
+	Priority int                 //越大越先下载，默认为0，相同时按列表中的顺序
+}
+
+// Read 读取列表文件，path为-时读取标准输入。扩展名为.json或.csv时按对应格式解析，
+// 标准输入以[开头时按JSON解析，其余按文本解析
+func Read(path string) ([]Entry, error) {
+	var data []byte
+	var err error
+	if path == Stdin {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, DetectFormat(path, data))
+}
+
+// DetectFormat 按扩展名判断列表格式，没有可识别的扩展名时以[开头的内容为JSON，其余为文本
+func DetectFormat(path string, data []byte) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON
+	case ".csv":
+		return CSV
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(trimBOM(data)), []byte("[")) {
+		return JSON
+	}
+	return Text
+}
+
+// Parse 按指定格式解析列表
+func Parse(data []byte, format Format) ([]Entry, error) {
+	data = trimBOM(data)
+	switch format {
+	case JSON:
+		return parseJSON(data)
+	case CSV:
+		return parseCSV(data)
+	default:
+		return parseText(data)
+	}
+}
+
+// SortByPriority 按优先级从高到低稳定排序
+func SortByPriority(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Priority > entries[j].Priority
+	})
+}
+
+// URLs 返回全部画廊的网址
+func URLs(entries []Entry) []string {
+	urls := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		urls = append(urls, entry.URL)
+	}
+	return urls
+}
+
+// trimBOM 去掉Windows记事本保存时加上的UTF-8 BOM
+func trimBOM(data []byte) []byte {
+	return bytes.TrimPrefix(data, []byte("\uFEFF"))
+}
+
+func parseText(data []byte) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields, err := splitFields(scanner.Text())
+		if err != nil {
+			return nil, i18n.Errorf("第%d行：%w", line, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		options := make(map[string]string)
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				return nil, i18n.Errorf("第%d行：选项应为key=value：%s", line, field)
+			}
+			options[key] = value
+		}
+		entry, err := newEntry(line, fields[0], options)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// splitFields 按空白分隔一行，双引号中的空白不分隔，#开始的字段及其后的内容为注释
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case quoted:
+			field.WriteRune(r)
+		case unicode.IsSpace(r):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		case r == '#' && !inField:
+			return fields, nil
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, i18n.Error("引号没有闭合")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func parseJSON(data []byte) ([]Entry, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, i18n.Errorf("JSON列表格式错误：%w", err)
+	}
+	var entries []Entry
+	for i, item := range items {
+		var u string
+		if err := json.Unmarshal(item, &u); err == nil {
+			entry, err := newEntry(i+1, u, nil)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+			continue
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(item, &fields); err != nil {
+			return nil, i18n.Errorf("第%d项应为网址或对象：%w", i+1, err)
+		}
+		options := make(map[string]string)
+		for key, value := range fields {
+			var err error
+			if options[key], err = cast.ToStringE(value); err != nil {
+				return nil, i18n.Errorf("第%d项中%s的值无效：%w", i+1, key, err)
+			}
+		}
+		u = options["url"]
+		delete(options, "url")
+		entry, err := newEntry(i+1, u, options)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseCSV(data []byte) ([]Entry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, i18n.Errorf("CSV列表格式错误：%w", err)
+	}
+	urlColumn := -1
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+		if header[i] == "url" {
+			urlColumn = i
+		}
+	}
+	if urlColumn < 0 {
+		return nil, i18n.Error("CSV列表的第一行必须包含url列")
+	}
+	var entries []Entry
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, i18n.Errorf("CSV列表格式错误：%w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		options := make(map[string]string)
+		for column, value := range record {
+			if column != urlColumn && strings.TrimSpace(value) != "" {
+				options[header[column]] = value
+			}
+		}
+		entry, err := newEntry(line, record[urlColumn], options)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// newEntry 检查网址与选项，生成列表中的一项
+func newEntry(line int, u string, options map[string]string) (Entry, error) {
+	entry := Entry{URL: strings.TrimSpace(u), Line: line}
+	if entry.URL == "" {
+		return entry, i18n.Errorf("第%d行：缺少画廊网址", line)
+	}
+	for key, value := range options {
+		value = strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "output":
+			entry.Output = value
+		case "pages":
+			entry.Pages, err = utils.ParsePageSelection(value)
+		case "profile":
+			entry.Profile = value
+		case "priority":
+			if entry.Priority, err = strconv.Atoi(value); err != nil {
+				err = i18n.Errorf("优先级应为整数：%s", value)
+			}
+		default:
+			err = i18n.Errorf("未知的选项%s，可用的选项：%s", key, strings.Join(Keys, ", "))
+		}
+		if err != nil {
+			return entry, i18n.Errorf("第%d行：%w", line, err)
+		}
+	}
+	return entry, nil
+}
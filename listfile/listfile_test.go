@@ -0,0 +1,88 @@
+package listfile
+
+import (
+	"EhDownloader/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse_text(t *testing.T) {
+	data := "\uFEFF# 精选列表\r\n" +
+		"https://e-hentai.org/g/1111111/1111111111/\r\n" +
+		"\r\n" +
+		"  https://e-hentai.org/g/2222222/2222222222/   pages=1-20,35 priority=2  # 第二章\n" +
+		"\n" +
+		`https://e-hentai.org/g/3333333/3333333333/ output="/mnt/my books" profile=fast` + "\n"
+	entries, err := Parse([]byte(data), Text)
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{URL: "https://e-hentai.org/g/1111111/1111111111/", Line: 2},
+		{URL: "https://e-hentai.org/g/2222222/2222222222/", Line: 4, Pages: utils.PageSelection{{Start: 1, End: 20}, {Start: 35, End: 35}}, Priority: 2},
+		{URL: "https://e-hentai.org/g/3333333/3333333333/", Line: 6, Output: "/mnt/my books", Profile: "fast"},
+	}, entries)
+
+	SortByPriority(entries)
+	assert.Equal(t, []string{
+		"https://e-hentai.org/g/2222222/2222222222/",
+		"https://e-hentai.org/g/1111111/1111111111/",
+		"https://e-hentai.org/g/3333333/3333333333/",
+	}, URLs(entries))
+
+	for _, line := range []string{
+		"https://e-hentai.org/g/1/a/ pages",
+		"https://e-hentai.org/g/1/a/ color=red",
+		"https://e-hentai.org/g/1/a/ priority=high",
+		"https://e-hentai.org/g/1/a/ pages=0",
+		`https://e-hentai.org/g/1/a/ output="unterminated`,
+	} {
+		_, err := Parse([]byte("# ok\n"+line), Text)
+		assert.ErrorContains(t, err, "2", line)
+	}
+}
+
+func TestParse_json(t *testing.T) {
+	entries, err := Parse([]byte(`[
+		"https://e-hentai.org/g/1111111/1111111111/",
+		{"url": "https://e-hentai.org/g/2222222/2222222222/", "pages": "40-", "priority": 1}
+	]`), JSON)
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{URL: "https://e-hentai.org/g/1111111/1111111111/", Line: 1},
+		{URL: "https://e-hentai.org/g/2222222/2222222222/", Line: 2, Pages: utils.PageSelection{{Start: 40}}, Priority: 1},
+	}, entries)
+
+	_, err = Parse([]byte(`[{"pages": "1"}]`), JSON)
+	assert.Error(t, err)
+	_, err = Parse([]byte(`[{"url": "https://e-hentai.org/g/1/a/", "tag": "x"}]`), JSON)
+	assert.Error(t, err)
+}
+
+func TestParse_csv(t *testing.T) {
+	entries, err := Parse([]byte("url,output,priority\n"+
+		"# 注释\n"+
+		"https://e-hentai.org/g/1111111/1111111111/,,\n"+
+		"https://e-hentai.org/g/2222222/2222222222/,\"/mnt/a, b\",3\n"), CSV)
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{URL: "https://e-hentai.org/g/1111111/1111111111/", Line: 3},
+		{URL: "https://e-hentai.org/g/2222222/2222222222/", Line: 4, Output: "/mnt/a, b", Priority: 3},
+	}, entries)
+
+	_, err = Parse([]byte("link\nhttps://e-hentai.org/g/1/a/\n"), CSV)
+	assert.Error(t, err)
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "list.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`["https://e-hentai.org/g/1111111/1111111111/"]`), 0644))
+	entries, err := Read(path)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Equal(t, JSON, DetectFormat(Stdin, []byte("  [\"x\"]")))
+	assert.Equal(t, Text, DetectFormat(Stdin, []byte("https://e-hentai.org/g/1/a/")))
+	assert.Equal(t, CSV, DetectFormat("list.CSV", nil))
+}
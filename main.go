@@ -1,36 +1,268 @@
 package main
 
 import (
+	"EhDownloader/convert"
 	"EhDownloader/eh"
-	"EhDownloader/utils"
+	"EhDownloader/export"
+	"EhDownloader/history"
+	"EhDownloader/hook"
+	"EhDownloader/i18n"
+	"EhDownloader/metadata"
+	"EhDownloader/progress"
+	"EhDownloader/report"
+	"errors"
 	"fmt"
-	"github.com/fatih/color"
-	"github.com/spf13/cast"
 	"github.com/urfave/cli/v2"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 )
 
-const infoJsonPath = "galleryInfo.json"
+const (
+	infoJsonPath = "galleryInfo.json"
+	version      = "0.9.1"
+)
 
 var (
-	onlyInfo        bool
+	preview         bool
+	retryRounds     int
+	force           bool
+	upgrade         bool
+	gidDirName      bool
+	layoutFormat    string
+	routeRules      cli.StringSlice
+	formats         cli.StringSlice
+	metadataNames   cli.StringSlice
+	filenameFormat  string
+	originalNames   bool
+	maxNameBytes    int
+	normalizeNames  bool
+	cookie          string
+	userAgent       string
+	headers         cli.StringSlice
+	proxy           string
+	parallelism     int
+	delay           time.Duration
+	thumbsPerPage   int
+	onImage         string
+	onDone          string
+	onFailed        string
+	hookTimeout     time.Duration
+	convertFormat   string
+	convertQuality  int
+	manga           bool
+	volumePages     int
+	jsonOutput      bool
+	noProgress      bool
+	dryRun          bool
+	pageSpec        string
 	outputDir       string
 	url             string
 	listFilePath    string
-	galleryUrlRegex = regexp.MustCompile(`^https://e-hentai.org/g/[a-z0-9]*/[a-z0-9]{10}/$`)
+	galleryUrlRegex = regexp.MustCompile(`^https://(e-hentai|exhentai)\.org/g/[a-z0-9]*/[a-z0-9]{10}/$`)
+)
+
+var errAlreadyDownloaded = i18n.Error("已有下载记录")
+
+// 进程退出码
+const (
+	exitOK     = 0
+	exitFailed = 1 //有画廊下载失败或其他错误
+	exitUsage  = 2 //参数或配置错误
+	exitBanned = 3 //IP被封禁或图片配额用完
 )
 
+// usageError 参数或配置错误，以exitUsage退出
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+
+func (e usageError) Unwrap() error { return e.err }
+
+// usage 把err标记为参数错误，err为nil时返回nil
+func usage(err error) error {
+	if err == nil {
+		return nil
+	}
+	return usageError{err: err}
+}
+
+// exitCode 按app.Run返回的错误决定进程退出码
+func exitCode(err error) int {
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, eh.ErrBanned), errors.Is(err, eh.ErrQuotaExceeded):
+		return exitBanned
+	case errors.As(err, &usageErr):
+		return exitUsage
+	default:
+		return exitFailed
+	}
+}
+
 type GalleryDownloader struct {
 	InfoJsonPath string
+	History      *history.Store    //全局下载历史，为nil时不检查也不记录
+	Force        bool              //忽略下载历史强制重新下载
+	Metadata     []metadata.Writer //除画廊信息文件外额外写入的元数据文件
+	Convert      convert.Options   //下载完成后的图片格式转换
+	Formats      []string          //下载完成后导出的格式
+	Export       export.Options
+	Hooks        *hook.Runner      //下载过程中执行的用户命令，为nil时不执行
+	Report       *report.Writer    //以NDJSON输出下载事件，为nil时不输出
+	Progress     *progress.Display //显示下载进度，为nil时不显示
+}
+
+// Download 下载一个画廊，完成或失败后执行对应的钩子，已有下载记录而跳过时不执行
+func (gd *GalleryDownloader) Download(outputDir string, url string, opts eh.Options) (eh.Result, error) {
+	if gd.Hooks.Enabled(hook.ImageSaved) {
+		opts.ImageSaved = func(info eh.GalleryInfo, galleryDir string, imagePath string) {
+			_ = gd.Hooks.Run(gd.payload(hook.ImageSaved, info, galleryDir, url, imagePath, nil))
+		}
+	}
+	if gd.Report != nil {
+		opts.Progress = func(info eh.GalleryInfo, done int, total int) {
+			_ = gd.Report.Emit(report.Event{Type: report.Progress, URL: url, Gid: info.Gid, Done: done, Total: total})
+		}
+		opts.ImageFailed = func(info eh.GalleryInfo, imagePageUrl string, err error) {
+			_ = gd.Report.Emit(report.Event{Type: report.ImageFailed, URL: url, Gid: info.Gid, Image: imagePageUrl, Error: err.Error()})
+		}
+	}
+	if gd.Progress != nil {
+		reportProgress := opts.Progress
+		opts.Progress = func(info eh.GalleryInfo, done int, total int) {
+			gd.Progress.Gallery(info.Title, done, total, info.FileSize)
+			if reportProgress != nil {
+				reportProgress(info, done, total)
+			}
+		}
+		opts.TrackImage = func(_ eh.GalleryInfo, imageTitle string, size int64) io.WriteCloser {
+			return gd.Progress.TrackImage(imageTitle, size)
+		}
+	}
+	result, err := gd.download(outputDir, url, opts)
+	if errors.Is(err, errAlreadyDownloaded) {
+		return result, err
+	}
+	event := hook.GalleryDone
+	if err != nil {
+		event = hook.GalleryFailed
+	}
+	_ = gd.Hooks.Run(gd.payload(event, result.Info, result.BaseDir, url, "", err))
+	return result, err
+}
+
+// payload 生成传给钩子的上下文，画廊信息尚未获取时gid从url中解析
+func (gd *GalleryDownloader) payload(event hook.Event, info eh.GalleryInfo, galleryDir string, url string, imagePath string, err error) hook.Payload {
+	payload := hook.Payload{Event: event, Gid: info.Gid, URL: url, GalleryDir: galleryDir, Image: imagePath, Status: "ok"}
+	if info.URL != "" {
+		payload.URL = info.URL
+	}
+	if payload.Gid == "" {
+		payload.Gid, _, _ = eh.ParseGalleryUrl(url)
+	}
+	if galleryDir != "" {
+		payload.InfoJson = filepath.Join(galleryDir, gd.InfoJsonPath)
+	}
+	if err != nil {
+		payload.Status = "failed"
+		payload.Error = err.Error()
+	}
+	return payload
+}
+
+// check 检查url格式，并按下载历史判断是否应跳过，已下载时返回包装了errAlreadyDownloaded的错误
+func (gd *GalleryDownloader) check(url string, opts eh.Options) error {
+	if !galleryUrlRegex.MatchString(url) {
+		return i18n.Errorf("未知的url格式：%s", url)
+	}
+	gid, _, err := eh.ParseGalleryUrl(url)
+	if err != nil {
+		return err
+	}
+
+	//升级模式下需要先获取画廊信息才能知道是否有新版本，因此不按历史跳过
+	if gd.History != nil && !gd.Force && !opts.Upgrade {
+		entry, found, err := gd.History.Get(gid)
+		if err != nil {
+			return err
+		}
+		if found {
+			return i18n.Errorf("%w：%s 已于%s下载到%s", errAlreadyDownloaded,
+				entry.Title, entry.FinishedAt.Local().Format(time.DateTime), entry.Path)
+		}
+	}
+	return nil
 }
 
-func (gd *GalleryDownloader) Download(outputDir string, url string, onlyInfo bool) error {
-	if galleryUrlRegex.MatchString(url) {
-		return eh.DownloadGallery(outputDir, gd.InfoJsonPath, url, onlyInfo)
+// Plan 按与下载相同的规则生成画廊的下载计划，不下载也不记录历史
+func (gd *GalleryDownloader) Plan(outputDir string, url string, opts eh.Options) (eh.Plan, error) {
+	if err := gd.check(url, opts); err != nil {
+		return eh.Plan{}, err
 	}
-	return fmt.Errorf("未知的url格式：%s", url)
+	return eh.PlanGallery(outputDir, gd.InfoJsonPath, url, opts)
+}
+
+func (gd *GalleryDownloader) download(outputDir string, url string, opts eh.Options) (eh.Result, error) {
+	if err := gd.check(url, opts); err != nil {
+		return eh.Result{}, err
+	}
+
+	result, err := eh.DownloadGallery(outputDir, gd.InfoJsonPath, url, opts)
+	if err != nil {
+		return result, err
+	}
+	for _, w := range gd.Metadata {
+		if err := w.Write(result.BaseDir, result.Info); err != nil {
+			return result, i18n.Errorf("写入%s元数据失败：%w", w.Name(), err)
+		}
+	}
+	//仅信息和预览模式不算下载完成，不转换、导出或记录历史
+	if opts.OnlyInfo || opts.Preview {
+		return result, nil
+	}
+	//先转换格式，导出的文件中使用转换后的图片
+	if gd.Convert.Enabled() {
+		converted, err := convert.Gallery(result.BaseDir, gd.Convert)
+		if err != nil {
+			return result, i18n.Errorf("转换图片格式失败：%w", err)
+		}
+		fmt.Println(i18n.T("转换格式的图片数量:"), converted)
+	}
+	for _, format := range gd.Formats {
+		paths, err := export.Export(format, result.BaseDir, result.Info, gd.Export)
+		if err != nil {
+			return result, i18n.Errorf("导出%s失败：%w", format, err)
+		}
+		fmt.Println(i18n.T("已导出:"), strings.Join(paths, ", "))
+	}
+
+	//只下载了部分页时不记录历史，之后仍可下载整个画廊
+	if gd.History == nil || len(opts.Pages) > 0 {
+		return result, nil
+	}
+	//升级模式下实际下载的可能是新版本，按实际画廊记录
+	gid, token, err := eh.ParseGalleryUrl(result.Info.URL)
+	if err != nil {
+		return result, err
+	}
+	path, _ := filepath.Abs(result.BaseDir)
+	return result, gd.History.Put(history.Entry{
+		Gid:        gid,
+		Token:      token,
+		Title:      result.Info.Title,
+		Path:       path,
+		ImageCount: result.Info.TotalImage,
+		FinishedAt: time.Now(),
+		Version:    version,
+	})
 }
 
 func getExecutionTime(startTime time.Time, endTime time.Time) string {
@@ -41,71 +273,112 @@ func getExecutionTime(startTime time.Time, endTime time.Time) string {
 	seconds := int(duration.Seconds()) % 60
 
 	if hours > 0 {
-		return fmt.Sprintf("%d时%d分%d秒", hours, minutes, seconds)
+		return i18n.Sprintf("%d时%d分%d秒", hours, minutes, seconds)
 	} else if minutes > 0 {
-		return fmt.Sprintf("%d分%d秒", minutes, seconds)
+		return i18n.Sprintf("%d分%d秒", minutes, seconds)
 	} else {
-		return fmt.Sprintf("%d秒", seconds)
+		return i18n.Sprintf("%d秒", seconds)
 	}
 }
 
-func main() {
-	//设置输出颜色
-	successColor := color.New(color.Bold, color.FgGreen).FprintlnFunc()
-	failColor := color.New(color.Bold, color.FgRed).FprintlnFunc()
-	errCount := 0
+// commandFlags 合并子命令的参数，加上配置参数与对应的环境变量
+func commandFlags(groups ...[]cli.Flag) []cli.Flag {
+	var flags []cli.Flag
+	for _, group := range groups {
+		flags = append(flags, group...)
+	}
+	return withEnv(append(flags, configFlags()...))
+}
 
+func main() {
+	presetLang(os.Args[1:])
 	app := &cli.App{
 		Name:      "EhDownloader",
-		UsageText: "EhDownloader -u <url> | -l <file>",
-		Version:   "0.9.1",
-		Flags: []cli.Flag{
-			&cli.BoolFlag{Name: "info", Aliases: []string{"i"}, Destination: &onlyInfo, Usage: "只下载画廊信息"},
-			&cli.StringFlag{Name: "url", Aliases: []string{"u"}, Destination: &url, Usage: "画廊网址"},
-			&cli.StringFlag{Name: "list", Aliases: []string{"l"}, Destination: &listFilePath, Usage: "包含画廊网址的文件"},
-			&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Destination: &outputDir, Value: "images", Usage: "输出目录"},
+		Usage:     i18n.T("E-Hentai画廊下载器"),
+		UsageText: "EhDownloader <command> [options]",
+		Version:   version,
+		Flags:     withEnv([]cli.Flag{langFlag()}),
+		Before:    applyLang,
+		OnUsageError: func(_ *cli.Context, err error, _ bool) error {
+			return usage(err)
 		},
-		Action: func(c *cli.Context) error {
-			var galleryUrlList []string
-			if url != "" {
-				galleryUrlList = append(galleryUrlList, url)
-			} else if listFilePath != "" {
-				var err error
-				galleryUrlList, err = utils.ReadListFile(listFilePath)
-				if err != nil {
-					return err
-				}
-			}
-
-			//记录开始时间
-			startTime := time.Now()
-
-			//创建下载器
-			downloader := GalleryDownloader{InfoJsonPath: infoJsonPath}
-			for _, u := range galleryUrlList {
-				successColor(os.Stdout, "开始下载gallery:", u)
-				err := downloader.Download(outputDir, u, onlyInfo)
-				if err != nil {
-					failColor(os.Stderr, "下载失败:", err, "\n")
-					errCount++
-				} else {
-					successColor(os.Stdout, "gallery下载完毕:", u, "\n")
-				}
-			}
-
-			//记录结束时间
-			endTime := time.Now()
-			//计算执行时间，单位为秒
-			successColor(os.Stdout, "所有gallery下载完毕，共耗时:", getExecutionTime(startTime, endTime))
-			if errCount > 0 {
-				return fmt.Errorf("有" + cast.ToString(errCount) + "个下载失败")
-			}
-
-			return nil
+		Commands: []*cli.Command{
+			{
+				Name:      "download",
+				Aliases:   []string{"dl"},
+				Usage:     i18n.T("下载画廊"),
+				UsageText: "EhDownloader download [options] <url>... | -u <url> | -l <file>",
+				Flags: commandFlags([]cli.Flag{
+					&cli.StringFlag{Name: "url", Aliases: []string{"u"}, Destination: &url, Usage: i18n.T("画廊网址")},
+					&cli.StringFlag{Name: "list", Aliases: []string{"l"}, Destination: &listFilePath, Usage: i18n.T("包含画廊网址的文件，-为标准输入。每行一个网址，其后可用key=value指定output、pages、profile、priority，也可以是.json或.csv文件。进度保存在同目录的队列文件中")},
+				}, downloadFlags()),
+				Before: beforeCommand,
+				Action: downloadAction,
+			},
+			{
+				Name:      "info",
+				Usage:     i18n.T("只获取画廊信息，默认以JSON输出"),
+				UsageText: "EhDownloader info [options] <url>... | -l <file>",
+				Flags: commandFlags([]cli.Flag{
+					&cli.StringFlag{Name: "list", Aliases: []string{"l"}, Destination: &listFilePath, Usage: i18n.T("包含画廊网址的文件")},
+					&cli.BoolFlag{Name: "save", Usage: i18n.T("将画廊信息写入输出目录中的画廊目录，而不是输出")},
+					&cli.StringSliceFlag{Name: "metadata", Destination: &metadataNames, Usage: i18n.Sprintf("--save时额外写入的元数据文件，可多次指定：%s", strings.Join(metadata.Names(), ", "))},
+				}, networkFlags(), dirFlags()),
+				Before: beforeCommand,
+				Action: infoAction,
+			},
+			{
+				Name:      "verify",
+				Usage:     i18n.T("检查已下载的画廊目录中的图片是否齐全且完整"),
+				UsageText: i18n.T("EhDownloader verify [options] [<dir>...]，不指定目录时检查输出目录中的全部画廊"),
+				Flags: commandFlags([]cli.Flag{
+					&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Destination: &outputDir, Value: "images", Usage: i18n.T("输出目录")},
+				}, filenameFlags()),
+				Before: beforeCommand,
+				Action: verifyAction,
+			},
+			{
+				Name:      "retry",
+				Usage:     i18n.T("重新下载列表文件中失败的画廊"),
+				UsageText: "EhDownloader retry [options] -l <file> [--save-list <file>]",
+				Flags: commandFlags([]cli.Flag{
+					&cli.StringFlag{Name: "list", Aliases: []string{"l"}, Destination: &listFilePath, Usage: i18n.T("包含画廊网址的文件，必须指定")},
+					&cli.StringFlag{Name: "save-list", Usage: i18n.T("不下载，只把失败的网址导出为新的列表文件")},
+				}, downloadFlags()),
+				Before: beforeCommand,
+				Action: retryAction,
+			},
+			{
+				Name:      "import",
+				Usage:     i18n.T("从聊天记录、HTML书签、收藏页或Markdown等任意文件中找出画廊链接，去重后加入列表文件"),
+				UsageText: "EhDownloader import [options] <file>...",
+				Flags: commandFlags([]cli.Flag{
+					&cli.StringFlag{Name: "list", Aliases: []string{"l"}, Destination: &listFilePath, Usage: i18n.T("追加到的列表文件，已有的画廊不再加入，不指定时把网址输出到stdout")},
+					&cli.BoolFlag{Name: "dry-run", Destination: &dryRun, Usage: i18n.T("只显示汇总和待加入的网址，不写入列表文件")},
+				}),
+				Before: beforeCommand,
+				Action: importAction,
+			},
+			{
+				Name:      "export",
+				Usage:     i18n.T("将已下载的画廊目录转换图片格式或导出为CBZ、EPUB、PDF"),
+				UsageText: i18n.T("EhDownloader export --format <format> [options] [<dir>...]，不指定目录时导出输出目录中的全部画廊"),
+				Flags: commandFlags([]cli.Flag{
+					&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Destination: &outputDir, Value: "images", Usage: i18n.T("输出目录")},
+				}, exportFlags()),
+				Before: beforeCommand,
+				Action: exportAction,
+			},
 		},
 	}
-	if err := app.Run(os.Args); err != nil {
+	for _, command := range app.Commands {
+		command.OnUsageError = func(_ *cli.Context, err error, _ bool) error {
+			return usage(err)
+		}
+	}
+	err := app.Run(os.Args)
+	if err != nil {
 		failColor(os.Stderr, err)
 	}
-
+	os.Exit(exitCode(err))
 }
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/i18n"
+	"strings"
+	"time"
+)
+
+// Writer 为画廊目录生成某个图库工具可以识别的元数据文件
+type Writer interface {
+	// Name 命令行中选择该Writer使用的名称
+	Name() string
+	// Write 根据画廊信息在galleryDir中写入元数据文件
+	Write(galleryDir string, info eh.GalleryInfo) error
+}
+
+// 内置的Writer，按名称排列
+var writers = []Writer{
+	calibreWriter{},
+	ehviewerWriter{},
+	ezeWriter{},
+	hydrusWriter{},
+	tachiyomiWriter{},
+}
+
+// Names 返回所有内置Writer的名称
+func Names() []string {
+	names := make([]string, len(writers))
+	for i, w := range writers {
+		names[i] = w.Name()
+	}
+	return names
+}
+
+// Lookup 按名称查找Writer
+func Lookup(name string) (Writer, error) {
+	for _, w := range writers {
+		if w.Name() == name {
+			return w, nil
+		}
+	}
+	return nil, i18n.Errorf("未知的元数据格式：%s，可选：%s", name, strings.Join(Names(), ", "))
+}
+
+// LookupAll 按名称依次查找多个Writer
+func LookupAll(names []string) ([]Writer, error) {
+	var result []Writer
+	for _, name := range names {
+		w, err := Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, w)
+	}
+	return result, nil
+}
+
+// postedTime 解析画廊的发布时间，E-Hentai显示的时间为UTC
+func postedTime(info eh.GalleryInfo) (time.Time, bool) {
+	posted, err := time.Parse("2006-01-02 15:04", info.Posted)
+	return posted, err == nil
+}
@@ -0,0 +1,81 @@
+package metadata
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testGalleryInfo = eh.GalleryInfo{
+	Gid:        "2569708",
+	Token:      "4bd9316841",
+	URL:        "https://e-hentai.org/g/2569708/4bd9316841/",
+	Title:      "[中信出版社] 流浪地球2电影制作手记 The Wandering Earth II FLIM HAND BOOK",
+	TitleJpn:   "流浪地球2电影制作手记",
+	Category:   "Non-H",
+	Uploader:   "someone",
+	Posted:     "2023-06-05 08:09",
+	TotalImage: 2,
+	TagList: map[string][]string{
+		"language": {"chinese", "translated"},
+		"artist":   {"guo fan"},
+		"other":    {"artbook"},
+		"parody":   {"the wandering earth"},
+	},
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestLookup(t *testing.T) {
+	w, err := Lookup("eze")
+	assert.NoError(t, err)
+	assert.Equal(t, "eze", w.Name())
+
+	_, err = LookupAll([]string{"calibre", "comicrack"})
+	assert.ErrorContains(t, err, "comicrack")
+}
+
+func TestWriters(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.jpg", "2.png"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	all, err := LookupAll(Names())
+	assert.NoError(t, err)
+	for _, w := range all {
+		assert.NoError(t, w.Write(dir, testGalleryInfo), w.Name())
+	}
+
+	var eze struct {
+		GalleryInfo ezeGalleryInfo `json:"gallery_info"`
+	}
+	assert.NoError(t, utils.LoadCache(filepath.Join(dir, "info.json"), &eze))
+	assert.Equal(t, "chinese", eze.GalleryInfo.Language)
+	assert.True(t, eze.GalleryInfo.Translated)
+	assert.Equal(t, "non-h", eze.GalleryInfo.Category)
+	assert.Equal(t, []int{2023, 6, 5, 8, 9, 0}, eze.GalleryInfo.UploadDate)
+	assert.Equal(t, 2569708, eze.GalleryInfo.Source.Gid)
+
+	var details tachiyomiDetails
+	assert.NoError(t, utils.LoadCache(filepath.Join(dir, "details.json"), &details))
+	assert.Equal(t, "guo fan", details.Author)
+	assert.Equal(t, []string{"artist:guo fan", "language:chinese", "language:translated", "other:artbook", "parody:the wandering earth"}, details.Genre)
+
+	assert.Equal(t, "title:"+testGalleryInfo.Title+"\nartist:guo fan\nlanguage:chinese\nlanguage:translated\nother:artbook\nparody:the wandering earth\npage:2\n",
+		readFile(t, filepath.Join(dir, "2.png.txt")))
+
+	opf := readFile(t, filepath.Join(dir, "metadata.opf"))
+	assert.Contains(t, opf, `<dc:creator opf:role="aut">guo fan</dc:creator>`)
+	assert.Contains(t, opf, `<dc:date>2023-06-05T08:09:00+00:00</dc:date>`)
+	assert.Contains(t, opf, `<dc:language>zh</dc:language>`)
+	assert.Contains(t, opf, `<meta name="calibre:series" content="the wandering earth"/>`)
+
+	assert.Equal(t, "VERSION2\n00000000\n2569708\n4bd9316841\n1\n1\n40\n2\n", readFile(t, filepath.Join(dir, ".ehviewer")))
+}
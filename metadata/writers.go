@@ -0,0 +1,208 @@
+package metadata
+
+import (
+	"EhDownloader/eh"
+	"EhDownloader/utils"
+	"bytes"
+	"fmt"
+	"github.com/spf13/cast"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// writeJSON 以与galleryInfo.json相同的缩进格式写入json文件
+func writeJSON(path string, data any) error {
+	return utils.BuildCache(filepath.Dir(path), filepath.Base(path), data)
+}
+
+// ezeWriter LANraragi的eze插件读取的info.json
+type ezeWriter struct{}
+
+type ezeSource struct {
+	Site   string `json:"site"`
+	Gid    int    `json:"gid"`
+	Token  string `json:"token"`
+	Parent string `json:"parent_gallery,omitempty"`
+}
+
+type ezeGalleryInfo struct {
+	Title         string              `json:"title"`
+	TitleOriginal string              `json:"title_original"`
+	Link          string              `json:"link"`
+	Category      string              `json:"category"`
+	Tags          map[string][]string `json:"tags"`
+	Language      string              `json:"language"`
+	Translated    bool                `json:"translated"`
+	UploadDate    []int               `json:"upload_date,omitempty"`
+	Source        ezeSource           `json:"source"`
+}
+
+func (ezeWriter) Name() string { return "eze" }
+
+func (ezeWriter) Write(galleryDir string, info eh.GalleryInfo) error {
+	galleryInfo := ezeGalleryInfo{
+		Title:         info.Title,
+		TitleOriginal: info.TitleJpn,
+		Link:          info.URL,
+		Category:      strings.ToLower(info.Category),
+		Tags:          info.TagList,
+		Translated:    slices.Contains(info.TagList["language"], "translated"),
+		Source: ezeSource{
+			Site:   "e-hentai",
+			Gid:    cast.ToInt(info.Gid),
+			Token:  info.Token,
+			Parent: info.Parent,
+		},
+	}
+	for _, language := range info.TagList["language"] {
+		if language != "translated" && language != "rewrite" {
+			galleryInfo.Language = language
+			break
+		}
+	}
+	if posted, ok := postedTime(info); ok {
+		galleryInfo.UploadDate = []int{posted.Year(), int(posted.Month()), posted.Day(), posted.Hour(), posted.Minute(), posted.Second()}
+	}
+	return writeJSON(filepath.Join(galleryDir, "info.json"), map[string]any{"gallery_info": galleryInfo})
+}
+
+// tachiyomiWriter Tachiyomi/Mihon本地源读取的details.json
+type tachiyomiWriter struct{}
+
+type tachiyomiDetails struct {
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	Artist      string   `json:"artist"`
+	Description string   `json:"description"`
+	Genre       []string `json:"genre"`
+	Status      string   `json:"status"`
+}
+
+func (tachiyomiWriter) Name() string { return "tachiyomi" }
+
+func (tachiyomiWriter) Write(galleryDir string, info eh.GalleryInfo) error {
+	artists := strings.Join(info.TagList["artist"], ", ")
+	author := strings.Join(info.TagList["group"], ", ")
+	if author == "" {
+		author = artists
+	}
+	details := tachiyomiDetails{
+		Title:       info.Title,
+		Author:      author,
+		Artist:      artists,
+		Description: strings.TrimSpace(info.TitleJpn + "\n" + info.URL),
+		Genre:       info.NamespacedTags(nil),
+		Status:      "2", //已完结
+	}
+	return writeJSON(filepath.Join(galleryDir, "details.json"), details)
+}
+
+// hydrusWriter 为每张图片生成Hydrus导入时读取的同名.txt标签文件，每行一个标签
+type hydrusWriter struct{}
+
+func (hydrusWriter) Name() string { return "hydrus" }
+
+func (hydrusWriter) Write(galleryDir string, info eh.GalleryInfo) error {
+	tags := append([]string{"title:" + info.Title}, info.NamespacedTags(nil)...)
+	content := []byte(strings.Join(tags, "\n") + "\n")
+	images, err := utils.ListImageFiles(galleryDir)
+	if err != nil {
+		return err
+	}
+	for i, image := range images {
+		page := fmt.Sprintf("page:%d\n", i+1)
+		if err := os.WriteFile(image+".txt", append(content, page...), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// calibreWriter Calibre读取的metadata.opf
+type calibreWriter struct{}
+
+var calibreTemplate = template.Must(template.New("opf").Funcs(template.FuncMap{"esc": utils.EscapeXML}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid_id" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:identifier opf:scheme="URL" id="uuid_id">{{esc .Info.URL}}</dc:identifier>
+    <dc:title>{{esc .Info.Title}}</dc:title>
+{{- range .Creators}}
+    <dc:creator opf:role="aut">{{esc .}}</dc:creator>
+{{- end}}
+{{- if .Date}}
+    <dc:date>{{.Date}}</dc:date>
+{{- end}}
+{{- if .Info.Uploader}}
+    <dc:publisher>{{esc .Info.Uploader}}</dc:publisher>
+{{- end}}
+{{- range .Languages}}
+    <dc:language>{{esc .}}</dc:language>
+{{- end}}
+{{- range .Tags}}
+    <dc:subject>{{esc .}}</dc:subject>
+{{- end}}
+{{- if .Info.TitleJpn}}
+    <dc:description>{{esc .Info.TitleJpn}}</dc:description>
+{{- end}}
+{{- if .Series}}
+    <meta name="calibre:series" content="{{esc .Series}}"/>
+{{- end}}
+  </metadata>
+</package>
+`))
+
+func (calibreWriter) Name() string { return "calibre" }
+
+func (calibreWriter) Write(galleryDir string, info eh.GalleryInfo) error {
+	data := struct {
+		Info      eh.GalleryInfo
+		Creators  []string
+		Languages []string //Calibre使用ISO 639代码
+		Tags      []string
+		Date      string
+		Series    string //与ComicInfo.xml一致，使用原作标签
+	}{
+		Info:     info,
+		Creators: append(append([]string{}, info.TagList["artist"]...), info.TagList["group"]...),
+		Tags:     info.NamespacedTags(nil),
+		Series:   strings.Join(info.TagList["parody"], ", "),
+	}
+	if language := eh.LanguageISO(info.TagList["language"]); language != "" {
+		data.Languages = append(data.Languages, language)
+	}
+	if posted, ok := postedTime(info); ok {
+		data.Date = posted.Format("2006-01-02T15:04:05+00:00")
+	}
+
+	var buffer bytes.Buffer
+	if err := calibreTemplate.Execute(&buffer, data); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(galleryDir, "metadata.opf"), buffer.Bytes(), 0644)
+}
+
+// ehviewerWriter EhViewer下载目录中的.ehviewer文件，EhViewer导入时据此识别画廊
+type ehviewerWriter struct{}
+
+// EhViewer每页缩略图的数量，与下载时的目录页一致
+const ehviewerPreviewPerPage = 40
+
+func (ehviewerWriter) Name() string { return "ehviewer" }
+
+func (ehviewerWriter) Write(galleryDir string, info eh.GalleryInfo) error {
+	lines := []string{
+		"VERSION2",
+		fmt.Sprintf("%08x", 0), //startPage
+		info.Gid,
+		info.Token,
+		"1", //已废弃的mode字段
+		cast.ToString(int(math.Ceil(float64(info.TotalImage) / ehviewerPreviewPerPage))),
+		cast.ToString(ehviewerPreviewPerPage),
+		cast.ToString(info.TotalImage),
+	}
+	return os.WriteFile(filepath.Join(galleryDir, ".ehviewer"), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
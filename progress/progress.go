@@ -0,0 +1,417 @@
+package progress
+
+import (
+	"EhDownloader/i18n"
+	"bytes"
+	"fmt"
+	"github.com/mattn/go-isatty"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	barWidth       = 30
+	nameWidth      = 24
+	refreshEvery   = 200 * time.Millisecond
+	clearLine      = "\x1b[2K"
+	cursorUpFormat = "\x1b[%dA"
+)
+
+// Display 下载进度显示。终端中在底部重绘列表总进度、当前画廊进度和正在传输的图片，
+// 其他输出显示在进度条上方；非终端时每次进度变化输出一行
+type Display struct {
+	mu        sync.Mutex
+	out       io.Writer
+	tty       bool
+	listTotal int
+	listDone  int
+	gallery   *galleryState
+	images    []*Transfer //正在传输的图片，按开始时间排列
+	lines     int         //上次绘制的行数，重绘前需清除
+	pending   []byte      //捕获的输出中还没有换行的部分
+	stop      chan struct{}
+	stopped   chan struct{}
+	restore   func()
+}
+
+// galleryState 当前画廊的进度
+type galleryState struct {
+	title     string
+	done      int
+	total     int
+	size      int64 //画廊总大小，0表示未知
+	received  int64 //本次运行收到的字节数
+	saved     int   //本次运行保存的图片数
+	startedAt time.Time
+	last      string //plain模式下最近保存的图片
+}
+
+// Transfer 一张图片的传输进度，写入的数据只计数不保存
+type Transfer struct {
+	display   *Display
+	name      string
+	size      int64 //Content-Length，未知时为-1
+	received  int64
+	startedAt time.Time
+}
+
+// New 创建向out输出的进度显示，tty为false时输出普通的行
+func New(out io.Writer, tty bool) *Display {
+	return &Display{out: out, tty: tty}
+}
+
+// NewStdout 创建向stdout输出的进度显示，stdout不是终端时输出普通的行
+func NewStdout() *Display {
+	return New(os.Stdout, isTerminal(os.Stdout))
+}
+
+// Start 开始显示共listTotal个画廊的下载进度。终端中定时重绘，并把stdout和日志改为显示在进度条上方。d为nil时不显示
+func (d *Display) Start(listTotal int) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.listTotal = listTotal
+	d.mu.Unlock()
+	if !d.tty {
+		return
+	}
+	d.capture()
+	d.stop = make(chan struct{})
+	d.stopped = make(chan struct{})
+	go func() {
+		defer close(d.stopped)
+		ticker := time.NewTicker(refreshEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.mu.Lock()
+				d.redraw(time.Now())
+				d.mu.Unlock()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// capture 把stdout、日志以及同为终端的stderr经过Display输出，避免与进度条混在一起
+func (d *Display) capture() {
+	logOutput := log.Writer()
+	log.SetOutput(d)
+	restores := []func(){func() { log.SetOutput(logOutput) }}
+	if restore, err := d.redirect(&os.Stdout); err == nil {
+		restores = append(restores, restore)
+	}
+	if isTerminal(os.Stderr) {
+		if restore, err := d.redirect(&os.Stderr); err == nil {
+			restores = append(restores, restore)
+		}
+	}
+	d.restore = func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+// redirect 把*file换成管道，管道中的内容写入Display，返回恢复原文件的函数
+func (d *Display) redirect(file **os.File) (func(), error) {
+	original := *file
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	*file = w
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(d, r)
+		close(copied)
+	}()
+	return func() {
+		*file = original
+		_ = w.Close()
+		<-copied
+	}, nil
+}
+
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// Stop 停止显示并清除进度条，恢复stdout和日志输出，可以重复调用
+func (d *Display) Stop() {
+	if d == nil {
+		return
+	}
+	if d.stop != nil {
+		close(d.stop)
+		<-d.stopped
+		d.stop = nil
+	}
+	if d.restore != nil {
+		d.restore()
+		d.restore = nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tty {
+		d.clear()
+	}
+	if len(d.pending) > 0 {
+		_, _ = d.out.Write(append(d.pending, '\n'))
+		d.pending = nil
+	}
+}
+
+// Write 把一行或多行输出显示在进度条上方，不完整的行等到换行时再输出
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = append(d.pending, p...)
+	end := bytes.LastIndexByte(d.pending, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	lines := d.pending[:end+1]
+	d.printAbove(lines)
+	d.pending = append(d.pending[:0], d.pending[end+1:]...)
+	return len(p), nil
+}
+
+// printAbove 清除进度条，输出text后重绘
+func (d *Display) printAbove(text []byte) {
+	if !d.tty {
+		_, _ = d.out.Write(text)
+		return
+	}
+	d.clear()
+	_, _ = d.out.Write(text)
+	d.draw(time.Now())
+}
+
+// Gallery 更新当前画廊的进度，标题变化时视为开始下载新的画廊
+func (d *Display) Gallery(title string, done int, total int, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gallery == nil || d.gallery.title != title {
+		d.gallery = &galleryState{title: title, size: size, startedAt: time.Now()}
+	} else if done > d.gallery.done {
+		d.gallery.saved += done - d.gallery.done
+	}
+	d.gallery.done, d.gallery.total = done, total
+	if !d.tty {
+		d.printLine(time.Now())
+	}
+}
+
+// printLine 非终端时输出一行当前画廊的进度
+func (d *Display) printLine(now time.Time) {
+	g := d.gallery
+	line := fmt.Sprintf("[%d/%d] %s: %d/%d", d.listDone+1, d.listTotal, g.title, g.done, g.total)
+	if g.last != "" {
+		line += " " + g.last
+	}
+	if g.saved > 0 {
+		line += i18n.Sprintf("，%s/s，剩余%s", FormatBytes(g.rate(now)), FormatDuration(g.eta(now)))
+	}
+	_, _ = fmt.Fprintln(d.out, line)
+	g.last = ""
+}
+
+// FinishGallery 当前画廊下载结束，计入列表总进度
+func (d *Display) FinishGallery() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.listDone++
+	d.gallery = nil
+	d.images = nil
+	if d.tty {
+		d.redraw(time.Now())
+	}
+}
+
+// TrackImage 开始跟踪一张图片的传输，size未知时为-1
+func (d *Display) TrackImage(name string, size int64) io.WriteCloser {
+	t := &Transfer{display: d, name: filepath.Base(name), size: size, startedAt: time.Now()}
+	d.mu.Lock()
+	d.images = append(d.images, t)
+	d.mu.Unlock()
+	return t
+}
+
+func (t *Transfer) Write(p []byte) (int, error) {
+	d := t.display
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t.received += int64(len(p))
+	if d.gallery != nil {
+		d.gallery.received += int64(len(p))
+	}
+	return len(p), nil
+}
+
+// Close 图片传输结束，不再显示
+func (t *Transfer) Close() error {
+	d := t.display
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, image := range d.images {
+		if image == t {
+			d.images = append(d.images[:i], d.images[i+1:]...)
+			break
+		}
+	}
+	if d.gallery != nil {
+		d.gallery.last = fmt.Sprintf("%s %s %s/s", t.name, FormatBytes(t.received), FormatBytes(t.rate(time.Now())))
+	}
+	return nil
+}
+
+func (t *Transfer) rate(now time.Time) int64 {
+	return perSecond(t.received, now.Sub(t.startedAt))
+}
+
+// rate 本次运行的平均下载速度
+func (g *galleryState) rate(now time.Time) int64 {
+	return perSecond(g.received, now.Sub(g.startedAt))
+}
+
+// eta 预计剩余时间。按画廊总大小估算剩余字节数，总大小未知时按已保存图片的平均大小估算
+func (g *galleryState) eta(now time.Time) time.Duration {
+	rate := g.rate(now)
+	if rate <= 0 || g.total <= 0 {
+		return 0
+	}
+	remainingImages := int64(max(g.total-g.done, 0))
+	var remaining int64
+	if g.size > 0 {
+		remaining = g.size * remainingImages / int64(g.total)
+	} else if g.saved > 0 {
+		remaining = g.received / int64(g.saved) * remainingImages
+	}
+	return time.Duration(float64(remaining) / float64(rate) * float64(time.Second))
+}
+
+func perSecond(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
+
+// redraw 清除上次绘制的进度条并重新绘制
+func (d *Display) redraw(now time.Time) {
+	d.clear()
+	d.draw(now)
+}
+
+// clear 清除上次绘制的进度条，光标回到第一行开头
+func (d *Display) clear() {
+	if d.lines == 0 {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, cursorUpFormat, d.lines)
+	for i := 0; i < d.lines; i++ {
+		b.WriteString(clearLine + "\n")
+	}
+	fmt.Fprintf(&b, cursorUpFormat, d.lines)
+	_, _ = io.WriteString(d.out, b.String())
+	d.lines = 0
+}
+
+// draw 在光标处绘制进度条
+func (d *Display) draw(now time.Time) {
+	lines := d.render(now)
+	for _, line := range lines {
+		_, _ = io.WriteString(d.out, clearLine+line+"\n")
+	}
+	d.lines = len(lines)
+}
+
+// render 生成进度条的各行：列表总进度、当前画廊进度和每张正在传输的图片
+func (d *Display) render(now time.Time) []string {
+	if d.listTotal == 0 && d.gallery == nil {
+		return nil
+	}
+	lines := []string{fmt.Sprintf("%-*s %s %d/%d", nameWidth, i18n.T("全部画廊"), Bar(d.listDone, d.listTotal, barWidth), d.listDone, d.listTotal)}
+	if g := d.gallery; g != nil {
+		line := fmt.Sprintf("%-*s %s %d/%d", nameWidth, truncate(g.title, nameWidth), Bar(g.done, g.total, barWidth), g.done, g.total)
+		if g.received > 0 {
+			line += i18n.Sprintf("  %s/s  剩余%s", FormatBytes(g.rate(now)), FormatDuration(g.eta(now)))
+		}
+		lines = append(lines, line)
+	}
+	for _, t := range d.images {
+		line := fmt.Sprintf("  %-*s ", nameWidth-2, truncate(t.name, nameWidth-2))
+		if t.size > 0 {
+			line += fmt.Sprintf("%s %s/%s", Bar(int(t.received), int(t.size), barWidth), FormatBytes(t.received), FormatBytes(t.size))
+		} else {
+			line += FormatBytes(t.received)
+		}
+		lines = append(lines, line+fmt.Sprintf("  %s/s", FormatBytes(t.rate(now))))
+	}
+	return lines
+}
+
+// Bar 生成宽度为width的进度条，如[=======>      ]
+func Bar(done int, total int, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = min(done*width/total, width)
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		if filled > 0 {
+			bar = bar[:filled-1] + ">"
+		}
+		bar += strings.Repeat(" ", width-filled)
+	}
+	return "[" + bar + "]"
+}
+
+// FormatBytes 把字节数格式化为B、KiB、MiB或GiB
+func FormatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// FormatDuration 按时:分:秒格式化剩余时间，0表示未知
+func FormatDuration(d time.Duration) string {
+	if d <= 0 {
+		return "--:--"
+	}
+	seconds := int(d.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+	}
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
+
+// truncate 截断过长的名称，按字符而不是字节截断
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
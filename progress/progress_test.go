@@ -0,0 +1,97 @@
+package progress
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBar(t *testing.T) {
+	assert.Equal(t, "[          ]", Bar(0, 10, 10))
+	assert.Equal(t, "[====>     ]", Bar(5, 10, 10))
+	assert.Equal(t, "[==========]", Bar(10, 10, 10))
+	assert.Equal(t, "[==========]", Bar(12, 10, 10))
+	assert.Equal(t, "[    ]", Bar(3, 0, 4))
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "512 B", FormatBytes(512))
+	assert.Equal(t, "1.5 KiB", FormatBytes(1536))
+	assert.Equal(t, "42.8 MiB", FormatBytes(44826624))
+	assert.Equal(t, "--:--", FormatDuration(0))
+	assert.Equal(t, "01:20", FormatDuration(80*time.Second))
+	assert.Equal(t, "1:01:01", FormatDuration(3661*time.Second))
+}
+
+func TestGalleryState_eta(t *testing.T) {
+	start := time.Now()
+	g := &galleryState{done: 10, total: 20, size: 20 << 20, received: 5 << 20, saved: 5, startedAt: start}
+	//剩余一半即10 MiB，速度1 MiB/s
+	assert.Equal(t, 10*time.Second, g.eta(start.Add(5*time.Second)))
+	//不知道总大小时按已保存图片的平均大小估算
+	g.size = 0
+	assert.Equal(t, 10*time.Second, g.eta(start.Add(5*time.Second)))
+	g.received = 0
+	assert.Equal(t, time.Duration(0), g.eta(start.Add(5*time.Second)))
+}
+
+func TestDisplay_plain(t *testing.T) {
+	var out bytes.Buffer
+	d := New(&out, false)
+	d.Start(2)
+	d.Gallery("流浪地", 3, 5, 0)
+	tracker := d.TrackImage("/tmp/gallery/004.jpg", 1024)
+	_, _ = tracker.Write(make([]byte, 1024))
+	assert.NoError(t, tracker.Close())
+	d.Gallery("流浪地", 4, 5, 0)
+	_, _ = d.Write([]byte("log line\n"))
+	d.FinishGallery()
+	d.Stop()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "[1/2] 流浪地: 3/5", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "[1/2] 流浪地: 4/5 004.jpg 1.0 KiB"), lines[1])
+	assert.Contains(t, lines[1], "/s")
+	assert.Equal(t, "log line", lines[2])
+	assert.NotContains(t, out.String(), "\x1b[")
+}
+
+func TestDisplay_render(t *testing.T) {
+	var out bytes.Buffer
+	d := New(&out, true)
+	d.listTotal = 3
+	d.Gallery("流浪地", 2, 4, 4096)
+	tracker := d.TrackImage("003.jpg", 2048)
+	_, _ = tracker.Write(make([]byte, 1024))
+
+	lines := d.render(time.Now().Add(time.Second))
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "0/3")
+	assert.Contains(t, lines[1], "流浪地")
+	assert.Contains(t, lines[1], "2/4")
+	assert.Contains(t, lines[2], "003.jpg")
+	assert.Contains(t, lines[2], "1.0 KiB/2.0 KiB")
+
+	//输出显示在进度条上方，之后重绘进度条
+	_, _ = d.Write([]byte("hello\n"))
+	assert.Equal(t, 3, d.lines)
+	_ = tracker.Close()
+	d.FinishGallery()
+	assert.Equal(t, 1, d.lines)
+	assert.Contains(t, out.String(), "hello\n")
+}
+
+func TestDisplay_stopTwice(t *testing.T) {
+	stdout := os.Stdout
+	var out bytes.Buffer
+	d := New(&out, true)
+	d.Start(1)
+	assert.NotEqual(t, stdout, os.Stdout)
+	d.Stop()
+	d.Stop()
+	assert.Equal(t, stdout, os.Stdout)
+}
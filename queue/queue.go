@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"EhDownloader/utils"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type State string
+
+const (
+	Pending State = "pending"
+	Running State = "running"
+	Done    State = "done"
+	Failed  State = "failed"
+)
+
+// Job 列表文件中一个画廊url的下载状态
+type Job struct {
+	URL       string    `json:"url"`
+	State     State     `json:"state"`
+	Reason    string    `json:"reason,omitempty"` //最近一次失败的原因
+	Attempts  int       `json:"attempts"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Queue 持久化的下载队列，每次状态变化都会写回文件，程序中途退出后可以从断点继续
+type Queue struct {
+	path  string
+	Jobs  []*Job
+	index map[string]*Job
+}
+
+// PathForList 返回列表文件对应的队列文件路径，与列表文件放在同一目录
+func PathForList(listFilePath string) string {
+	return listFilePath + ".queue.json"
+}
+
+// Load 加载队列文件，文件不存在时返回空队列
+func Load(path string) (*Queue, error) {
+	q := &Queue{path: path, index: make(map[string]*Job)}
+	if utils.FileExists(path) {
+		if err := utils.LoadCache(path, &q.Jobs); err != nil {
+			return nil, err
+		}
+	}
+	for _, job := range q.Jobs {
+		q.index[job.URL] = job
+	}
+	return q, nil
+}
+
+// Sync 将列表中新出现的url加入队列，已有的任务保留原来的状态
+func (q *Queue) Sync(urls []string) error {
+	for _, u := range urls {
+		if _, ok := q.index[u]; ok {
+			continue
+		}
+		job := &Job{URL: u, State: Pending, UpdatedAt: time.Now()}
+		q.Jobs = append(q.Jobs, job)
+		q.index[u] = job
+	}
+	return q.Save()
+}
+
+// Runnable 返回需要执行的url：未完成的、失败的以及上次运行中断在running状态的任务
+func (q *Queue) Runnable() []string {
+	var urls []string
+	for _, job := range q.Jobs {
+		if job.State != Done {
+			urls = append(urls, job.URL)
+		}
+	}
+	return urls
+}
+
+// Failed 返回所有失败的任务
+func (q *Queue) Failed() []*Job {
+	var jobs []*Job
+	for _, job := range q.Jobs {
+		if job.State == Failed {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// Start 标记任务开始执行并累加尝试次数
+func (q *Queue) Start(u string) error {
+	job := q.index[u]
+	job.State = Running
+	job.Attempts++
+	job.UpdatedAt = time.Now()
+	return q.Save()
+}
+
+// Finish 根据err标记任务完成或失败
+func (q *Queue) Finish(u string, err error) error {
+	job := q.index[u]
+	if err != nil {
+		job.State = Failed
+		job.Reason = err.Error()
+	} else {
+		job.State = Done
+		job.Reason = ""
+	}
+	job.UpdatedAt = time.Now()
+	return q.Save()
+}
+
+// Save 先写入临时文件再重命名，避免写到一半时退出导致队列文件损坏
+func (q *Queue) Save() error {
+	dir, name := filepath.Split(q.path)
+	tmpName := name + ".tmp"
+	if err := utils.BuildCache(dir, tmpName, q.Jobs); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(dir, tmpName), q.path)
+}
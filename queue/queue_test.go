@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	path := PathForList(filepath.Join(t.TempDir(), "list.txt"))
+	urls := []string{
+		"https://e-hentai.org/g/1111111/1111111111/",
+		"https://e-hentai.org/g/2222222/2222222222/",
+		"https://e-hentai.org/g/3333333/3333333333/",
+	}
+
+	q, err := Load(path)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Sync(urls))
+	assert.Equal(t, urls, q.Runnable())
+
+	assert.NoError(t, q.Start(urls[0]))
+	assert.NoError(t, q.Finish(urls[0], nil))
+	assert.NoError(t, q.Start(urls[1]))
+	assert.NoError(t, q.Finish(urls[1], errors.New("重试3轮后仍有1张图片缺失：[7]")))
+	//模拟程序在下载第三个画廊时崩溃
+	assert.NoError(t, q.Start(urls[2]))
+
+	//重新运行同一个列表时，完成的任务被跳过，失败和中断的任务会重试
+	q, err = Load(path)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Sync(urls))
+	assert.Equal(t, urls[1:], q.Runnable())
+
+	failed := q.Failed()
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, urls[1], failed[0].URL)
+		assert.Equal(t, 1, failed[0].Attempts)
+		assert.Equal(t, "重试3轮后仍有1张图片缺失：[7]", failed[0].Reason)
+	}
+}
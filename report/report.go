@@ -0,0 +1,72 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Type 事件类型
+type Type string
+
+const (
+	GalleryStarted  Type = "gallery_started"  //开始下载一个画廊
+	Progress        Type = "progress"         //画廊中已有的图片数量变化
+	ImageFailed     Type = "image_failed"     //一张图片解析或保存失败
+	GalleryFinished Type = "gallery_finished" //一个画廊下载结束，Status为ok、failed或skipped
+	Summary         Type = "summary"          //全部画廊下载结束，Status为ok、failed或aborted
+	Plan            Type = "plan"             //--dry-run时一个画廊的下载计划，Status为download、skip或error
+)
+
+// Event 以一行JSON输出的事件，各类型只填写相关的字段。计数字段为0时也输出，以便区分0与没有该字段
+type Event struct {
+	Type        Type      `json:"type"`
+	Time        time.Time `json:"time"`
+	URL         string    `json:"url,omitempty"`
+	Gid         string    `json:"gid,omitempty"`
+	Title       string    `json:"title,omitempty"`
+	Dir         string    `json:"dir,omitempty"`
+	Image       string    `json:"image,omitempty"` //失败图片的页面地址
+	Done        int       `json:"done"`
+	Total       int       `json:"total"`
+	Missing     []int     `json:"missing,omitempty"`      //下载结束后仍缺失，或计划中需要下载的图片序号
+	Present     []int     `json:"present,omitempty"`      //计划中目录里已有的图片序号
+	Reused      []int     `json:"reused,omitempty"`       //计划中可以从旧版本复制的图片序号
+	Bytes       int64     `json:"bytes,omitempty"`        //计划中预计下载的字节数
+	Images      int       `json:"images,omitempty"`       //计划汇总中需要下载的图片总数
+	UpgradeFrom string    `json:"upgrade_from,omitempty"` //计划中升级前的画廊url
+	Reason      string    `json:"reason,omitempty"`       //计划中跳过的原因
+	Status      string    `json:"status,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Succeeded   int       `json:"succeeded"`
+	Failed      int       `json:"failed"`
+	Skipped     int       `json:"skipped"`
+	Duration    float64   `json:"duration,omitempty"` //秒
+}
+
+// Writer 将事件逐行写为NDJSON，可在多个goroutine中同时使用，nil表示不输出
+type Writer struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewWriter 创建向w写入事件的Writer
+func NewWriter(w io.Writer) *Writer {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &Writer{encoder: encoder}
+}
+
+// Emit 写入一个事件，Time为零值时使用当前时间
+func (w *Writer) Emit(event Event) error {
+	if w == nil {
+		return nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.encoder.Encode(event)
+}
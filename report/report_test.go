@@ -0,0 +1,48 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriter_Emit(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(done int) {
+			defer wg.Done()
+			assert.NoError(t, w.Emit(Event{Type: Progress, Gid: "2569708", Done: done, Total: 20}))
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, w.Emit(Event{Type: GalleryFinished, Title: "<流浪地>", Status: "ok", Time: time.Unix(0, 0)}))
+
+	scanner := bufio.NewScanner(&buffer)
+	var lines []map[string]any
+	for scanner.Scan() {
+		var line map[string]any
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line), scanner.Text())
+		lines = append(lines, line)
+	}
+	assert.Len(t, lines, 21)
+	assert.Equal(t, "progress", lines[0]["type"])
+	assert.NotEmpty(t, lines[0]["time"])
+	last := lines[20]
+	assert.Equal(t, "<流浪地>", last["title"])
+	assert.NotContains(t, last, "missing")
+	//计数为0时也要输出
+	assert.Equal(t, float64(0), last["done"])
+	assert.Equal(t, float64(0), last["failed"])
+}
+
+func TestWriter_Nil(t *testing.T) {
+	var w *Writer
+	assert.NoError(t, w.Emit(Event{Type: Summary}))
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"EhDownloader/i18n"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultFilenameTemplate 与早期版本一致的文件名，如1.jpg
+	DefaultFilenameTemplate = "{index}.{ext}"
+	// OriginalFilenameTemplate 保留上传时的原始文件名，以序号作前缀排序并避免重名，如007_scan_p007.jpg
+	OriginalFilenameTemplate = "{index:auto}_{name}.{ext}"
+)
+
+var templateFieldRegex = regexp.MustCompile(`\{(\w+)(?::(\w+))?}`)
+
+// FilenameFields 渲染图片文件名时可用的字段
+type FilenameFields struct {
+	Index int    //图片序号，从1开始
+	Total int    //画廊图片总数，用于{index:auto}计算补零宽度
+	Name  string //图片的原始文件名(不含后缀)
+	Hash  string //图片页url中的SHA-1前缀
+	Gid   string
+	Ext   string //不含点的后缀
+}
+
+// FilenameTemplate 图片文件名模板，支持{index}、{index:3}、{index:auto}、{name}、{hash}、{gid}、{ext}。
+// 模板中必须包含{index}，以便断点续传时从文件名中解析出图片序号
+type FilenameTemplate struct {
+	raw     string
+	pattern *regexp.Regexp
+	Policy  SanitizePolicy //生成文件名时使用的清理策略，超长时截断{name}
+}
+
+// ParseFilenameTemplate 解析文件名模板，并生成从文件名反查序号的正则
+func ParseFilenameTemplate(raw string) (*FilenameTemplate, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	indexCount := 0
+	last := 0
+	for _, match := range templateFieldRegex.FindAllStringSubmatchIndex(raw, -1) {
+		pattern.WriteString(regexp.QuoteMeta(raw[last:match[0]]))
+		last = match[1]
+		field, arg := raw[match[2]:match[3]], ""
+		if match[4] >= 0 {
+			arg = raw[match[4]:match[5]]
+		}
+		switch field {
+		case "index":
+			if arg != "" && arg != "auto" {
+				if _, err := strconv.Atoi(arg); err != nil {
+					return nil, i18n.Errorf("文件名模板中{index:%s}的宽度无效", arg)
+				}
+			}
+			indexCount++
+			pattern.WriteString(`(\d+)`)
+		case "name":
+			pattern.WriteString(`.*?`)
+		case "hash":
+			pattern.WriteString(`[0-9a-f]*`)
+		case "gid":
+			pattern.WriteString(`\d*`)
+		case "ext":
+			pattern.WriteString(`[0-9A-Za-z]+`)
+		default:
+			return nil, i18n.Errorf("文件名模板中有未知的字段{%s}", field)
+		}
+	}
+	pattern.WriteString(regexp.QuoteMeta(raw[last:]))
+	pattern.WriteString("$")
+	if indexCount != 1 {
+		return nil, i18n.Errorf("文件名模板中必须包含且只包含一个{index}：%s", raw)
+	}
+	return &FilenameTemplate{raw: raw, pattern: regexp.MustCompile(pattern.String()), Policy: DefaultSanitizePolicy}, nil
+}
+
+// MustParseFilenameTemplate 解析失败时panic，用于内置的模板
+func MustParseFilenameTemplate(raw string) *FilenameTemplate {
+	t, err := ParseFilenameTemplate(raw)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (t *FilenameTemplate) String() string {
+	return t.raw
+}
+
+// 渲染时{name}的占位符，清理和截断时再替换为原始文件名
+const namePlaceholder = "\x00"
+
+// Render 按模板生成文件名，结果已按Policy清理，超长时只截断{name}部分，
+// 序号与扩展名等其余部分不会被截断，否则无法再从文件名中解析出序号
+func (t *FilenameTemplate) Render(fields FilenameFields) (string, error) {
+	name := fields.Name
+	fields.Name = namePlaceholder
+	rendered := t.render(fields)
+	if prefix, suffix, found := strings.Cut(rendered, namePlaceholder); found {
+		return t.Policy.Fit(prefix, name, suffix)
+	}
+	return t.Policy.Fit(rendered, "", "")
+}
+
+func (t *FilenameTemplate) render(fields FilenameFields) string {
+	return templateFieldRegex.ReplaceAllStringFunc(t.raw, func(s string) string {
+		match := templateFieldRegex.FindStringSubmatch(s)
+		switch match[1] {
+		case "index":
+			width := 0
+			if match[2] == "auto" {
+				width = len(strconv.Itoa(fields.Total))
+			} else if match[2] != "" {
+				width, _ = strconv.Atoi(match[2])
+			}
+			return fmt.Sprintf("%0*d", width, fields.Index)
+		case "name":
+			return fields.Name
+		case "hash":
+			return fields.Hash
+		case "gid":
+			return fields.Gid
+		case "ext":
+			return fields.Ext
+		}
+		return s
+	})
+}
+
+// Index 从按模板生成的文件名中解析出图片序号
+func (t *FilenameTemplate) Index(filename string) (int, bool) {
+	match := t.pattern.FindStringSubmatch(filename)
+	if match == nil {
+		return 0, false
+	}
+	index, err := strconv.Atoi(match[1])
+	return index, err == nil
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"EhDownloader/i18n"
+	"strconv"
+	"strings"
+)
+
+// PageRange 一段页码，从1开始，End为0表示到最后一页
+type PageRange struct {
+	Start int
+	End   int
+}
+
+// PageSelection 画廊中要下载的页，由逗号分隔的页码与区间组成，如"1-20,35,40-"，为空表示全部
+type PageSelection []PageRange
+
+// ParsePageSelection 解析页码选择，空字符串返回nil，即选择全部
+func ParsePageSelection(spec string) (PageSelection, error) {
+	var selection PageSelection
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		start, end, isRange := strings.Cut(part, "-")
+		r := PageRange{}
+		var err error
+		if r.Start, err = strconv.Atoi(strings.TrimSpace(start)); err != nil || r.Start < 1 {
+			return nil, i18n.Errorf("无效的页码：%s", part)
+		}
+		if !isRange {
+			r.End = r.Start
+		} else if end = strings.TrimSpace(end); end != "" {
+			if r.End, err = strconv.Atoi(end); err != nil || r.End < r.Start {
+				return nil, i18n.Errorf("无效的页码：%s", part)
+			}
+		}
+		selection = append(selection, r)
+	}
+	return selection, nil
+}
+
+// Pages 返回在total页的画廊中选中的页码，升序且不重复，超出total的部分忽略
+func (s PageSelection) Pages(total int) []int {
+	selected := make([]bool, total+1)
+	for i := 1; i <= total; i++ {
+		selected[i] = len(s) == 0
+	}
+	for _, r := range s {
+		end := r.End
+		if end == 0 || end > total {
+			end = total
+		}
+		for i := r.Start; i <= end; i++ {
+			selected[i] = true
+		}
+	}
+	var pages []int
+	for i := 1; i <= total; i++ {
+		if selected[i] {
+			pages = append(pages, i)
+		}
+	}
+	return pages
+}
+
+// String 还原为"1-20,35,40-"的形式
+func (s PageSelection) String() string {
+	parts := make([]string, 0, len(s))
+	for _, r := range s {
+		switch r.End {
+		case r.Start:
+			parts = append(parts, strconv.Itoa(r.Start))
+		case 0:
+			parts = append(parts, strconv.Itoa(r.Start)+"-")
+		default:
+			parts = append(parts, strconv.Itoa(r.Start)+"-"+strconv.Itoa(r.End))
+		}
+	}
+	return strings.Join(parts, ",")
+}
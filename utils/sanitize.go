@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"EhDownloader/i18n"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Windows保留的设备名，带扩展名时同样不可用，如CON.txt
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizePolicy 文件名和目录名的清理策略
+type SanitizePolicy struct {
+	MaxBytes  int  //单个文件名或目录名的最大字节数，0表示不限制
+	Normalize bool //统一为NFC，并把全角字母、数字和符号转为半角
+}
+
+// DefaultSanitizePolicy 常见文件系统的文件名上限为255字节，
+// 预留的部分用于导出时追加的" Vol.01.cbz"、".tmp"等后缀
+var DefaultSanitizePolicy = SanitizePolicy{MaxBytes: 200}
+
+// Clean 替换非法字符，去掉控制字符和结尾的点与空格，并避开Windows保留名，不做长度限制
+func (p SanitizePolicy) Clean(name string) string {
+	if p.Normalize {
+		//先转半角再替换非法字符，全角的／、：等会被一并替换
+		name = norm.NFC.String(width.Fold.String(name))
+	}
+	name = ToSafeFilename(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, " ")
+	name = strings.TrimRight(name, ". ")
+
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		name = stem + "_" + name[len(stem):]
+	}
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
+
+// Fit 清理prefix+variable+suffix，超过MaxBytes时只截断variable，保留前后缀(如gid、序号和扩展名)。
+// 前后缀本身就超出上限时返回错误
+func (p SanitizePolicy) Fit(prefix string, variable string, suffix string) (string, error) {
+	name := p.Clean(prefix + variable + suffix)
+	if p.MaxBytes <= 0 || len(name) <= p.MaxBytes {
+		return name, nil
+	}
+	if fixed := p.Clean(prefix + suffix); len(fixed) > p.MaxBytes {
+		return "", i18n.Errorf("名称中不可截断的部分超过了%d字节：%s", p.MaxBytes, fixed)
+	}
+
+	//按超出的字节数缩短variable，清理后仍超出时继续缩短
+	variable = p.Clean(variable)
+	for keep := len(variable) - (len(name) - p.MaxBytes); ; keep-- {
+		name = p.Clean(prefix + TruncateUTF8(variable, max(keep, 0)) + suffix)
+		if len(name) <= p.MaxBytes || keep <= 0 {
+			return name, nil
+		}
+	}
+}
+
+// TruncateUTF8 将s截断到不超过maxBytes字节，不会截断在多字节字符中间
+func TruncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	end := maxBytes
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
@@ -1,15 +1,21 @@
 package utils
 
 import (
+	"EhDownloader/i18n"
+	"bytes"
 	"context"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"github.com/carlmjohnson/requests"
-	"github.com/spf13/cast"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +24,7 @@ import (
 const (
 	Parallelism = 5 //页面处理的并发量
 	DelayMs     = 330
+	AppName     = "EhDownloader"
 )
 
 type ImageInfo struct {
@@ -59,7 +66,7 @@ func BuildCache(saveDir string, cacheFile string, data interface{}) error {
 	// 打开文件用于写入数据
 	file, err := os.Create(filepath.Join(dir, cacheFile))
 	if err != nil {
-		fmt.Println("File creation error:", err)
+		fmt.Println(i18n.T("创建文件出错:"), err)
 		return err
 	}
 	defer func(file *os.File) {
@@ -74,7 +81,7 @@ func BuildCache(saveDir string, cacheFile string, data interface{}) error {
 	encoder.SetEscapeHTML(false)  // 禁用转义 HTML
 	err = encoder.Encode(data)
 	if err != nil {
-		fmt.Println("JSON encoding error:", err)
+		fmt.Println(i18n.T("JSON编码出错:"), err)
 		return err
 	}
 
@@ -86,7 +93,7 @@ func LoadCache(filePath string, result interface{}) error {
 	// 打开utf-8格式的文件用于读取数据
 	file, err := os.Open(filePath)
 	if err != nil {
-		fmt.Println("File open error:", err)
+		fmt.Println(i18n.T("打开文件出错:"), err)
 		return err
 	}
 	defer func(file *os.File) {
@@ -104,11 +111,127 @@ func LoadCache(filePath string, result interface{}) error {
 	return nil
 }
 
+// DataDir 返回本程序存放持久数据的目录(不存在时创建)，遵循各平台的惯例：
+// Linux为$XDG_DATA_HOME或~/.local/share，macOS为~/Library/Application Support，Windows为%LocalAppData%
+func DataDir() (string, error) {
+	var base string
+	switch runtime.GOOS {
+	case "windows":
+		base = os.Getenv("LocalAppData")
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, "Library", "Application Support")
+	default:
+		base = os.Getenv("XDG_DATA_HOME")
+		if base == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			base = filepath.Join(home, ".local", "share")
+		}
+	}
+	if base == "" {
+		return "", i18n.Errorf("无法确定用户数据目录")
+	}
+
+	dir := filepath.Join(base, AppName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
 func FileExists(filePath string) bool {
 	_, err := os.Stat(filePath)
 	return err == nil || os.IsExist(err)
 }
 
+// CopyFile 将src复制到dst，dst所在目录不存在时自动创建
+func CopyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// ImageSuffixes 作为画廊页面的图片后缀
+var ImageSuffixes = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// NaturalLess 按自然顺序比较两个文件名，其中的数字按数值比较，使2.jpg排在10.jpg之前
+func NaturalLess(a string, b string) bool {
+	for a != "" && b != "" {
+		numA, restA := splitLeadingDigits(a)
+		numB, restB := splitLeadingDigits(b)
+		if numA != "" && numB != "" {
+			//去掉前导零后先比较位数再逐位比较，避免大数溢出
+			trimA, trimB := strings.TrimLeft(numA, "0"), strings.TrimLeft(numB, "0")
+			if len(trimA) != len(trimB) {
+				return len(trimA) < len(trimB)
+			}
+			if trimA != trimB {
+				return trimA < trimB
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func splitLeadingDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// ListImageFiles 按页码顺序返回画廊目录中的图片路径
+func ListImageFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !slices.Contains(ImageSuffixes, strings.ToLower(filepath.Ext(entry.Name()))) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return NaturalLess(names[i], names[j])
+	})
+	files := make([]string, len(names))
+	for i, name := range names {
+		files[i] = filepath.Join(dir, name)
+	}
+	return files, nil
+}
+
 // GetFileTotal 用于获取指定目录下指定后缀的文件数量
 func GetFileTotal(dirPath string, fileSuffixes []string) int {
 	var count int // 用于存储文件数量的变量
@@ -136,35 +259,12 @@ func GetFileTotal(dirPath string, fileSuffixes []string) int {
 	})
 
 	if err != nil {
-		fmt.Println("遍历目录出错:", err)
+		fmt.Println(i18n.T("遍历目录出错:"), err)
 	}
 
 	return count
 }
 
-// ReadListFile 用于按行读取列表文件，返回一个字符串切片
-func ReadListFile(filePath string) ([]string, error) {
-	var list []string
-	file, err := os.Open(filePath)
-	if err != nil {
-		return list, err
-	}
-	defer func(file *os.File) {
-		err := file.Close()
-		ErrorCheck(err)
-	}(file)
-
-	var line string
-	for {
-		_, err := fmt.Fscanln(file, &line)
-		if err != nil {
-			break
-		}
-		list = append(list, line)
-	}
-	return list, nil
-}
-
 func SaveImagesWithMultiRequest(c *http.Client, h http.Header, imageInfoList []ImageInfo, saveDir string) {
 	dir, err := filepath.Abs(saveDir)
 	ErrorCheck(err)
@@ -193,9 +293,9 @@ func SaveImagesWithMultiRequest(c *http.Client, h http.Header, imageInfoList []I
 				Headers(h).
 				Fetch(context.Background())
 			if err != nil {
-				log.Printf("Error saving image: %s by error %v", data.Title, err)
+				log.Printf(i18n.T("保存图片%s出错：%v"), data.Title, err)
 			} else {
-				log.Println("Image saved:", data.Title)
+				log.Println(i18n.T("图片已保存:"), data.Title)
 			}
 			time.Sleep(time.Millisecond * time.Duration(DelayMs))
 		}(data)
@@ -205,32 +305,44 @@ func SaveImagesWithMultiRequest(c *http.Client, h http.Header, imageInfoList []I
 	wg.Wait()
 }
 
-// CheckSequentialFileNames 检查指定目录中是否包含从1到maxNumber的连续数字命名的文件。
-// 返回是否连续存在所有数字以及缺失的文件名数字。
-func CheckSequentialFileNames(directory string, maxNumber int) (bool, []int) {
+// CheckSequentialFileNames 检查指定目录中是否包含序号从1到maxNumber、按tmpl命名的文件，tmpl为nil时使用默认模板。
+// 返回是否连续存在所有序号以及缺失的序号。
+func CheckSequentialFileNames(directory string, maxNumber int, tmpl *FilenameTemplate) (bool, []int) {
+	numbers := make([]int, maxNumber)
+	for i := range numbers {
+		numbers[i] = i + 1
+	}
+	return CheckFileNames(directory, numbers, tmpl)
+}
+
+// CheckFileNames 检查指定目录中是否包含numbers中每个序号按tmpl命名的文件，tmpl为nil时使用默认模板。
+// 返回是否全部存在以及按numbers顺序排列的缺失序号。
+func CheckFileNames(directory string, numbers []int, tmpl *FilenameTemplate) (bool, []int) {
+	if tmpl == nil {
+		tmpl = MustParseFilenameTemplate(DefaultFilenameTemplate)
+	}
 	// 读取目录中的所有文件和子目录（不会递归到子目录）
 	files, err := os.ReadDir(directory)
 	if err != nil {
 		return false, nil
 	}
 
-	// 创建一个map来跟踪存在的文件名
+	// 创建一个map来跟踪存在的序号
 	fileNames := make(map[int]bool)
 	for _, file := range files {
 		if file.IsDir() {
 			continue // 忽略目录
 		}
-		// 去除文件名中的扩展名
-		name := file.Name()
-		nameWithoutExt := name[:len(name)-len(filepath.Ext(name))]
-		// 将文件名转换为整数，忽略转换错误
-		fileNames[cast.ToInt(nameWithoutExt)] = true
+		// 按模板解析序号，忽略不符合模板的文件
+		if index, ok := tmpl.Index(file.Name()); ok {
+			fileNames[index] = true
+		}
 	}
 
-	// 检查从1到maxNumber的每个数字是否都有对应的文件，并记录缺失的数字
+	// 检查每个序号是否都有对应的文件，并记录缺失的序号
 	var missingNumbers []int
 	allPresent := true
-	for i := 1; i <= maxNumber; i++ {
+	for _, i := range numbers {
 		if !fileNames[i] {
 			allPresent = false
 			missingNumbers = append(missingNumbers, i)
@@ -239,3 +351,28 @@ func CheckSequentialFileNames(directory string, maxNumber int) (bool, []int) {
 
 	return allPresent, missingNumbers
 }
+
+// FormatRanges 把升序的序号合并为区间，如[1 2 3 5]为"1-3,5"
+func FormatRanges(numbers []int) string {
+	var parts []string
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] == numbers[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, fmt.Sprint(numbers[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", numbers[i], numbers[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// EscapeXML 转义XML文本中的特殊字符，供手写的XML模板使用
+func EscapeXML(s string) string {
+	var buffer bytes.Buffer
+	_ = xml.EscapeText(&buffer, []byte(s))
+	return buffer.String()
+}
@@ -1,10 +1,10 @@
 package utils
 
 import (
-	"bufio"
-	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -28,51 +28,156 @@ func TestToSafeFilename(t *testing.T) {
 	}
 }
 
-func TestReadListFile(t *testing.T) {
-	type args struct {
-		filePath string
+func TestListImageFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"10.jpg", "2.png", "1.jpg", "galleryInfo.json", "3.WEBP"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
 	}
+	got, err := ListImageFiles(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "1.jpg"),
+		filepath.Join(dir, "2.png"),
+		filepath.Join(dir, "3.WEBP"),
+		filepath.Join(dir, "10.jpg"),
+	}, got)
+}
+
+func TestFilenameTemplate(t *testing.T) {
+	fields := FilenameFields{Index: 7, Total: 468, Name: "scan_p007", Hash: "0196805342", Gid: "2569708", Ext: "jpg"}
 	tests := []struct {
-		name    string
-		args    args
-		want    []string
-		wantErr assert.ErrorAssertionFunc
+		template string
+		want     string
 	}{
-		{
-			name: "case1",
-			args: args{
-				filePath: "list.txt",
-			},
-			want: []string{
-				"https://e-hentai.org/g/1111111/1111111111/",
-				"https://e-hentai.org/g/2222222/2222222222/",
-				"https://e-hentai.org/g/3333333/3333333333/",
-			},
-			wantErr: assert.NoError,
-		},
+		{template: DefaultFilenameTemplate, want: "7.jpg"},
+		{template: "{index:4}.{ext}", want: "0007.jpg"},
+		{template: "{index:auto}.{ext}", want: "007.jpg"},
+		{template: "{gid}_{index:auto}_{hash}.{ext}", want: "2569708_007_0196805342.jpg"},
+		{template: "{index:auto} - {name}.{ext}", want: "007 - scan_p007.jpg"},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			func() {
-				file, _ := os.Create(tt.args.filePath)
-				defer file.Close()
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := ParseFilenameTemplate(tt.template)
+			assert.NoError(t, err)
+			got, err := tmpl.Render(fields)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			index, ok := tmpl.Index(got)
+			assert.True(t, ok)
+			assert.Equal(t, 7, index)
+		})
+	}
+
+	_, err := ParseFilenameTemplate("{name}.{ext}")
+	assert.Error(t, err)
+	_, err = ParseFilenameTemplate("{index}.{extension}")
+	assert.Error(t, err)
 
-				// 创建一个 bufio.Writer 来帮助按行写入数据
-				writer := bufio.NewWriter(file)
-				// 循环写入多行数据
-				for _, line := range tt.want {
-					fmt.Fprintln(writer, line)
-				}
+	//超长时只截断{name}，仍能解析出序号
+	tmpl := MustParseFilenameTemplate(OriginalFilenameTemplate)
+	tmpl.Policy = SanitizePolicy{MaxBytes: 16}
+	fields.Name = strings.Repeat("long", 10)
+	got, err := tmpl.Render(fields)
+	assert.NoError(t, err)
+	assert.Equal(t, "007_longlong.jpg", got)
+	index, ok := tmpl.Index(got)
+	assert.True(t, ok)
+	assert.Equal(t, 7, index)
+	//序号与扩展名本身就超长时报错，而不是截断
+	tmpl = MustParseFilenameTemplate("{gid}_{index:auto}_{hash}.{ext}")
+	tmpl.Policy = SanitizePolicy{MaxBytes: 16}
+	_, err = tmpl.Render(fields)
+	assert.Error(t, err)
+}
+
+func TestCheckSequentialFileNames(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"001.jpg", "002.png", "004.jpg", "004.jpg.txt", "galleryInfo.json"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	success, missing := CheckSequentialFileNames(dir, 5, MustParseFilenameTemplate("{index:auto}.{ext}"))
+	assert.False(t, success)
+	assert.Equal(t, []int{3, 5}, missing)
+	success, missing = CheckFileNames(dir, []int{2, 4}, MustParseFilenameTemplate("{index:auto}.{ext}"))
+	assert.True(t, success)
+	assert.Empty(t, missing)
+}
+
+func TestPageSelection(t *testing.T) {
+	selection, err := ParsePageSelection("1-3, 5,9-")
+	assert.NoError(t, err)
+	assert.Equal(t, PageSelection{{Start: 1, End: 3}, {Start: 5, End: 5}, {Start: 9}}, selection)
+	assert.Equal(t, "1-3,5,9-", selection.String())
+	assert.Equal(t, []int{1, 2, 3, 5, 9, 10}, selection.Pages(10))
+	assert.Equal(t, []int{1, 2}, selection.Pages(2))
 
-				// 刷新缓冲区并检查错误
-				writer.Flush()
-			}()
-			got, err := ReadListFile(tt.args.filePath)
-			if tt.wantErr(t, err) {
-				assert.Equal(t, tt.want, got)
-			}
-			//删除文件
-			os.Remove(tt.args.filePath)
+	selection, err = ParsePageSelection("")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, selection.Pages(3))
+	for _, spec := range []string{"0", "3-1", "a-b", "-5", "1-x"} {
+		_, err := ParsePageSelection(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestFormatRanges(t *testing.T) {
+	assert.Equal(t, "", FormatRanges(nil))
+	assert.Equal(t, "7", FormatRanges([]int{7}))
+	assert.Equal(t, "1-3,5,8-9", FormatRanges([]int{1, 2, 3, 5, 8, 9}))
+}
+
+func TestNaturalLess(t *testing.T) {
+	assert.True(t, NaturalLess("2.jpg", "10.jpg"))
+	assert.True(t, NaturalLess("002.jpg", "10.jpg"))
+	assert.True(t, NaturalLess("2569708_2.jpg", "2569708_10.jpg"))
+	assert.False(t, NaturalLess("10.jpg", "9.jpg"))
+	assert.True(t, NaturalLess("a.jpg", "b.jpg"))
+}
+
+func TestSanitizePolicy_Clean(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SanitizePolicy
+		in     string
+		want   string
+	}{
+		{name: "控制字符", in: "a\tb\x00c", want: "abc"},
+		{name: "结尾的点和空格", in: " title... ", want: "title"},
+		{name: "保留名", in: "con", want: "con_"},
+		{name: "带扩展名的保留名", in: "NUL.jpg", want: "NUL_.jpg"},
+		{name: "空名称", in: "...", want: "_"},
+		{name: "全角转半角", policy: SanitizePolicy{Normalize: true}, in: "ＡＢＣ１２３／ｘ", want: "ABC123_x"},
+		{name: "NFC", policy: SanitizePolicy{Normalize: true}, in: "é", want: "é"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Clean(tt.in))
 		})
 	}
 }
+
+func TestSanitizePolicy_Fit(t *testing.T) {
+	policy := SanitizePolicy{MaxBytes: 20}
+	//每个汉字3字节，截断后保留完整的字符和gid
+	tests := []struct {
+		prefix, variable, suffix string
+		want                     string
+	}{
+		{variable: "流浪地球2电影制作手记", suffix: " [2569708]", want: "流浪地 [2569708]"},
+		{variable: "short", suffix: " [1]", want: "short [1]"},
+		{prefix: "001_", variable: "scan_p0000007", suffix: ".jpg", want: "001_scan_p000000.jpg"},
+	}
+	for _, tt := range tests {
+		got, err := policy.Fit(tt.prefix, tt.variable, tt.suffix)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+	_, err := policy.Fit("", "x", " [this suffix is way too long]")
+	assert.Error(t, err)
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	assert.Equal(t, "流浪", TruncateUTF8("流浪地球", 8))
+	assert.Equal(t, "流浪地球", TruncateUTF8("流浪地球", 12))
+	assert.Equal(t, "", TruncateUTF8("流浪地球", 2))
+}